@@ -3,8 +3,12 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
@@ -22,18 +26,53 @@ type Stats struct {
 }
 
 type OfflineStats struct {
+	Wins         int                      `json:"wins"`
+	Losses       int                      `json:"losses"`
+	Draws        int                      `json:"draws"`
+	ByDifficulty map[string]OfflineRecord `json:"byDifficulty"`
+}
+
+type OfflineRecord struct {
 	Wins   int `json:"wins"`
 	Losses int `json:"losses"`
 	Draws  int `json:"draws"`
 }
 
+// AnalyzeRequest — позиция для /analyze: ходы от начала партии или клетки доски
+type AnalyzeRequest struct {
+	game.Options
+	Moves       []game.Move `json:"moves"`
+	Board       game.Board  `json:"board"`
+	Turn        string      `json:"turn"`
+	ActiveBoard *int        `json:"activeBoard"`
+}
+
+// RoomRequest — тело POST /rooms и /rooms/join. Пароль передаётся в теле, а не в query,
+// чтобы не оставаться в логах запросов и прокси
+type RoomRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message"`
 }
 
+const (
+	// maxAnalyzeBody — предел размера тела POST /analyze
+	maxAnalyzeBody = 64 << 10
+	// maxConcurrentAnalyses — сколько позиций /analyze разбирает одновременно
+	maxConcurrentAnalyses = 4
+	// maxRoomBody — предел размера тела запросов к комнатам
+	maxRoomBody = 4 << 10
+)
+
 var gm *game.GameManager
 
+// analyzeSlots ограничивает число одновременных разборов: каждый занимает ядро до AnalysisTimeBudget
+var analyzeSlots = make(chan struct{}, maxConcurrentAnalyses)
+
 func main() {
 	err := db.Init()
 	if err != nil {
@@ -41,15 +80,44 @@ func main() {
 	}
 	defer db.DB.Close()
 
+	if err := game.LoadExternalEngines(os.Getenv("EXTERNAL_ENGINES")); err != nil {
+		log.Fatal("Failed to load external engines:", err)
+	}
+
 	gm = game.NewGameManager()
 	ws.InitGameManager(gm)
+	if maxWait := os.Getenv("MAX_QUEUE_WAIT"); maxWait != "" {
+		gm.MaxQueueWait, err = time.ParseDuration(maxWait)
+		if err != nil {
+			log.Fatal("Invalid MAX_QUEUE_WAIT:", err)
+		}
+	}
+	if fallback := os.Getenv("AI_FALLBACK_AFTER"); fallback != "" {
+		gm.AIFallbackAfter, err = time.ParseDuration(fallback)
+		if err != nil {
+			log.Fatal("Invalid AI_FALLBACK_AFTER:", err)
+		}
+	}
+	if roomTTL := os.Getenv("ROOM_TTL"); roomTTL != "" {
+		gm.RoomTTL, err = time.ParseDuration(roomTTL)
+		if err != nil {
+			log.Fatal("Invalid ROOM_TTL:", err)
+		}
+	}
+	go gm.RunMatchmaker(game.DefaultMatchInterval, nil)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", ws.Handler)
 	mux.HandleFunc("/stats", handleStats)
 	mux.HandleFunc("/quick-game", handleQuickGame)
 	mux.HandleFunc("/offline-game", handleOfflineGame)
+	mux.HandleFunc("/rooms", handleCreateRoom)
+	mux.HandleFunc("/rooms/join", handleJoinRoom)
 	mux.HandleFunc("/offline-stats", handleOfflineStats)
+	mux.HandleFunc("/engines", handleEngines)
+	mux.HandleFunc("/menace-stats", handleMenaceStats)
+	mux.HandleFunc("/analyze", handleAnalyze)
+	mux.HandleFunc("/annotations", handleAnnotations)
 
 	handler := errorMiddleware(corsMiddleware(mux))
 	log.Println("Server started on :8080")
@@ -60,7 +128,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Player-Token")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -127,48 +195,284 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleQuickGame(w http.ResponseWriter, r *http.Request) {
-	nickname := utils.GenerateNickname()
-	var playerID int
-	err := db.DB.QueryRow("INSERT INTO users (nickname) VALUES ($1) ON CONFLICT (nickname) DO UPDATE SET nickname = EXCLUDED.nickname || '_' || (random() * 1000)::integer RETURNING id", nickname).Scan(&playerID)
+// parseGameOptions читает параметры партии из query: variant, size, winLength и misere
+func parseGameOptions(r *http.Request) (game.Options, error) {
+	query := r.URL.Query()
+	opts := game.Options{Variant: query.Get("variant")}
+	if sizeStr := query.Get("size"); sizeStr != "" {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil {
+			return opts, fmt.Errorf("invalid size: %v", err)
+		}
+		opts.Size = size
+	}
+	if winStr := query.Get("winLength"); winStr != "" {
+		winLength, err := strconv.Atoi(winStr)
+		if err != nil {
+			return opts, fmt.Errorf("invalid winLength: %v", err)
+		}
+		opts.WinLength = winLength
+	}
+	if misereStr := query.Get("misere"); misereStr != "" {
+		misere, err := strconv.ParseBool(misereStr)
+		if err != nil {
+			return opts, fmt.Errorf("invalid misere: %v", err)
+		}
+		opts.Misere = misere
+	}
+	return opts.Normalize()
+}
+
+// Player — игрок, от имени которого пришёл запрос
+type Player struct {
+	ID       int
+	Nickname string
+	Token    string
+}
+
+// requestPlayer возвращает игрока по токену из заголовка X-Player-Token, чтобы рейтинг
+// и статистика переходили из партии в партию. Без заголовка создаётся новый игрок со своим
+// токеном. При ошибке сам отвечает клиенту и возвращает ok == false
+func requestPlayer(w http.ResponseWriter, r *http.Request) (Player, bool) {
+	if token := r.Header.Get("X-Player-Token"); token != "" {
+		player := Player{Token: token}
+		var err error
+		player.ID, player.Nickname, err = game.PlayerByToken(token)
+		if errors.Is(err, game.ErrUnknownToken) {
+			sendError(w, http.StatusUnauthorized, "Unauthorized", err.Error())
+			return player, false
+		}
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "Database error", "Failed to fetch user")
+			log.Println("DB error:", err)
+			return player, false
+		}
+		return player, true
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Internal server error", "Failed to create user")
+		log.Println("Token error:", err)
+		return Player{}, false
+	}
+	player := Player{Token: token}
+	// Ник может быть занят, тогда пробуем другой
+	for attempt := 0; attempt < 5; attempt++ {
+		player.Nickname = utils.GenerateNickname()
+		err = db.DB.QueryRow(
+			"INSERT INTO users (nickname, token_hash) VALUES ($1, $2) ON CONFLICT (nickname) DO NOTHING RETURNING id",
+			player.Nickname, utils.HashToken(token),
+		).Scan(&player.ID)
+		if err != sql.ErrNoRows {
+			break
+		}
+	}
 	if err != nil {
 		sendError(w, http.StatusInternalServerError, "Database error", "Failed to create user")
 		log.Println("DB error:", err)
+		return player, false
+	}
+	return player, true
+}
+
+func handleQuickGame(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseGameOptions(r)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid input", err.Error())
 		return
 	}
 
-	opponentID := gm.FindOpponent(playerID)
+	autoAI := false
+	if autoAIStr := r.URL.Query().Get("aiFallback"); autoAIStr != "" {
+		autoAI, err = strconv.ParseBool(autoAIStr)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid input", "invalid aiFallback")
+			return
+		}
+	}
+
+	player, ok := requestPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	opponentID := gm.FindOpponent(player.ID, opts, autoAI)
 	response := map[string]interface{}{
 		"status":   "waiting",
-		"playerID": playerID,
-		"nickname": nickname,
+		"playerID": player.ID,
+		"token":    player.Token,
+		"nickname": player.Nickname,
 	}
 	if opponentID != 0 {
 		response["status"] = "started"
 		response["opponentID"] = opponentID
 	}
-	log.Printf("Quick game for player %d: %v", playerID, response)
+	log.Printf("Quick game for player %d: %s", player.ID, response["status"])
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Failed to encode response:", err)
+	}
+}
+
+// handleCreateRoom создаёт приватную комнату с кодом приглашения. Параметры доски — в query,
+// необязательный пароль — в теле POST-запроса
+func handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Invalid method", "Use POST")
+		return
+	}
+	opts, err := parseGameOptions(r)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid input", err.Error())
+		return
+	}
+	req, err := roomRequest(w, r)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid input", err.Error())
+		return
+	}
+
+	player, ok := requestPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	room, err := gm.CreateRoom(player.ID, opts, req.Password)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Internal server error", "Failed to create room")
+		log.Println("Room error:", err)
+		return
+	}
+	response := map[string]interface{}{
+		"status":    "waiting",
+		"playerID":  player.ID,
+		"token":     player.Token,
+		"nickname":  player.Nickname,
+		"code":      room.Code,
+		"password":  req.Password != "",
+		"expiresAt": room.ExpiresAt,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Failed to encode response:", err)
+	}
+}
+
+// handleJoinRoom подключает второго игрока к комнате по коду и паролю из тела POST-запроса
+// и начинает партию. Игрок создаётся, только когда код и пароль подошли
+func handleJoinRoom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Invalid method", "Use POST")
+		return
+	}
+	req, err := roomRequest(w, r)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid input", err.Error())
+		return
+	}
+	if req.Code == "" {
+		sendError(w, http.StatusBadRequest, "Invalid input", "code is required")
+		return
+	}
+
+	room, err := gm.ClaimRoom(req.Code, req.Password)
+	switch {
+	case errors.Is(err, game.ErrRoomNotFound):
+		sendError(w, http.StatusNotFound, "Not found", err.Error())
+		return
+	case errors.Is(err, game.ErrWrongPassword):
+		sendError(w, http.StatusForbidden, "Forbidden", err.Error())
+		return
+	case err != nil:
+		sendError(w, http.StatusBadRequest, "Invalid input", err.Error())
+		return
+	}
+
+	player, ok := requestPlayer(w, r)
+	if !ok {
+		gm.ReleaseRoom(room)
+		return
+	}
+	g, err := gm.StartRoomGame(room, player.ID)
+	switch {
+	case errors.Is(err, game.ErrCreateGame):
+		sendError(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	case errors.Is(err, game.ErrHostLeft):
+		sendError(w, http.StatusNotFound, "Not found", err.Error())
+		return
+	case err != nil:
+		sendError(w, http.StatusBadRequest, "Invalid input", err.Error())
+		return
+	}
+	response := map[string]interface{}{
+		"status":     "started",
+		"playerID":   player.ID,
+		"token":      player.Token,
+		"nickname":   player.Nickname,
+		"opponentID": g.Player1ID,
+		"gameID":     g.ID,
+	}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Println("Failed to encode response:", err)
 	}
 }
 
+// roomRequest читает тело запроса к комнатам. Пустое тело — комната без пароля
+func roomRequest(w http.ResponseWriter, r *http.Request) (RoomRequest, error) {
+	var req RoomRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxRoomBody)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return req, fmt.Errorf("invalid body: %v", err)
+	}
+	return req, nil
+}
+
 func handleOfflineGame(w http.ResponseWriter, r *http.Request) {
-	nickname := utils.GenerateNickname()
-	var playerID int
-	err := db.DB.QueryRow("INSERT INTO users (nickname) VALUES ($1) ON CONFLICT (nickname) DO UPDATE SET nickname = EXCLUDED.nickname || '_' || (random() * 1000)::integer RETURNING id", nickname).Scan(&playerID)
+	opts, err := parseGameOptions(r)
 	if err != nil {
-		sendError(w, http.StatusInternalServerError, "Database error", "Failed to create user")
-		log.Println("DB error:", err)
+		sendError(w, http.StatusBadRequest, "Invalid input", err.Error())
+		return
+	}
+	difficulty := r.URL.Query().Get("difficulty")
+	if difficulty == "" {
+		difficulty = game.DefaultDifficulty
+	}
+	if !game.ValidDifficulty(difficulty) {
+		sendError(w, http.StatusBadRequest, "Invalid input", "difficulty must be one of easy, medium, hard, perfect")
+		return
+	}
+	engine := r.URL.Query().Get("engine")
+	if engine != "" && !game.HasEngine(engine) {
+		sendError(w, http.StatusBadRequest, "Invalid input", "unknown engine: "+engine)
+		return
+	}
+	if engine != "" && !game.EngineSupports(engine, opts) {
+		sendError(w, http.StatusBadRequest, "Invalid input", "engine "+engine+" does not support this board")
+		return
+	}
+
+	player, ok := requestPlayer(w, r)
+	if !ok {
 		return
 	}
 
-	gameID := gm.CreateOfflineGame(playerID)
+	gameID, err := gm.CreateOfflineGame(player.ID, opts, difficulty, engine)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":   "started",
-		"playerID": playerID,
-		"gameID":   gameID,
-		"nickname": nickname,
+		"status":     "started",
+		"playerID":   player.ID,
+		"token":      player.Token,
+		"gameID":     gameID,
+		"nickname":   player.Nickname,
+		"variant":    opts.Variant,
+		"size":       opts.Size,
+		"winLength":  opts.WinLength,
+		"misere":     opts.Misere,
+		"difficulty": difficulty,
+		"engine":     engine,
 	}); err != nil {
 		log.Println("Failed to encode response:", err)
 	}
@@ -183,20 +487,193 @@ func handleOfflineStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var stats OfflineStats
-	err = db.DB.QueryRow(
-		"SELECT wins, losses, draws FROM offline_stats WHERE player_id = $1",
+	stats := OfflineStats{ByDifficulty: make(map[string]OfflineRecord)}
+	rows, err := db.DB.Query(
+		"SELECT difficulty, wins, losses, draws FROM offline_stats WHERE player_id = $1",
 		playerID,
-	).Scan(&stats.Wins, &stats.Losses, &stats.Draws)
-	if err == sql.ErrNoRows {
-		stats = OfflineStats{Wins: 0, Losses: 0, Draws: 0}
-	} else if err != nil {
+	)
+	if err != nil {
 		sendError(w, http.StatusInternalServerError, "Database error", "Failed to fetch stats")
 		log.Println("DB error:", err)
 		return
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var difficulty sql.NullString
+		var record OfflineRecord
+		if err := rows.Scan(&difficulty, &record.Wins, &record.Losses, &record.Draws); err != nil {
+			sendError(w, http.StatusInternalServerError, "Database error", "Failed to fetch stats")
+			log.Println("DB error:", err)
+			return
+		}
+		// Партии, сыгранные до появления уровней, входят только в общий итог
+		if difficulty.Valid {
+			stats.ByDifficulty[difficulty.String] = record
+		}
+		stats.Wins += record.Wins
+		stats.Losses += record.Losses
+		stats.Draws += record.Draws
+	}
 
 	if err := json.NewEncoder(w).Encode(stats); err != nil {
 		log.Println("Failed to encode stats:", err)
 	}
-}
\ No newline at end of file
+}
+
+func handleMenaceStats(w http.ResponseWriter, r *http.Request) {
+	recent := 50
+	if recentStr := r.URL.Query().Get("recent"); recentStr != "" {
+		n, err := strconv.Atoi(recentStr)
+		if err != nil || n < 0 {
+			sendError(w, http.StatusBadRequest, "Invalid input", "Invalid recent")
+			return
+		}
+		recent = n
+	}
+
+	report, err := game.MenaceStats(recent)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Database error", "Failed to fetch MENACE stats")
+		log.Println("DB error:", err)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Println("Failed to encode MENACE stats:", err)
+	}
+}
+
+// handleAnalyze разбирает позицию: GET ?gameID=N[&ply=K] — сохранённую партию после K ходов
+// (по умолчанию после всех), POST — позицию из тела запроса
+func handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	var pos *game.Position
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		pos, err = storedPosition(r)
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxAnalyzeBody)
+		pos, err = requestPosition(r)
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Invalid method", "Use GET or POST")
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid input", err.Error())
+		return
+	}
+
+	select {
+	case analyzeSlots <- struct{}{}:
+		defer func() { <-analyzeSlots }()
+	default:
+		sendError(w, http.StatusServiceUnavailable, "Server busy", "Too many analyses in progress, try again later")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(game.Analyze(pos)); err != nil {
+		log.Println("Failed to encode analysis:", err)
+	}
+}
+
+func storedPosition(r *http.Request) (*game.Position, error) {
+	gameID, err := strconv.Atoi(r.URL.Query().Get("gameID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gameID: %v", err)
+	}
+	opts, moves, err := game.LoadGameMoves(gameID)
+	if err != nil {
+		log.Printf("Failed to load game %d: %v", gameID, err)
+		return nil, fmt.Errorf("game %d not found", gameID)
+	}
+	if plyStr := r.URL.Query().Get("ply"); plyStr != "" {
+		ply, err := strconv.Atoi(plyStr)
+		if err != nil || ply < 0 || ply > len(moves) {
+			return nil, fmt.Errorf("ply must be between 0 and %d", len(moves))
+		}
+		moves = moves[:ply]
+	}
+	return game.Replay(opts, moves)
+}
+
+func requestPosition(r *http.Request) (*game.Position, error) {
+	var req AnalyzeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid body: %v", err)
+	}
+	if req.Board == nil {
+		return game.Replay(req.Options, req.Moves)
+	}
+	activeBoard := game.AnyBoard
+	if req.ActiveBoard != nil {
+		activeBoard = *req.ActiveBoard
+	}
+	return game.PositionFromBoard(req.Options, req.Board, req.Turn, activeBoard)
+}
+
+// handleAnnotations возвращает разбор ходов завершённой партии. Если разбора ещё нет
+// (партия только что кончилась или сыграна до появления разбора), он запускается в фоне,
+// а ответ — 202 со статусом pending: запрос стоит повторить позже
+func handleAnnotations(w http.ResponseWriter, r *http.Request) {
+	gameID, err := strconv.Atoi(r.URL.Query().Get("gameID"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid input", "Invalid gameID")
+		return
+	}
+
+	var status string
+	if err := db.DB.QueryRow("SELECT status FROM games WHERE id = $1", gameID).Scan(&status); err != nil {
+		sendError(w, http.StatusNotFound, "Not found", "Game not found")
+		return
+	}
+	if status != "finished" {
+		sendError(w, http.StatusBadRequest, "Invalid input", "Game is not finished")
+		return
+	}
+
+	annotations, err := game.LoadAnnotations(gameID)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Database error", "Failed to fetch annotations")
+		log.Println("Annotations error:", err)
+		return
+	}
+	if len(annotations) == 0 {
+		_, moves, err := game.LoadGameMoves(gameID)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "Database error", "Failed to fetch annotations")
+			log.Println("Annotations error:", err)
+			return
+		}
+		if len(moves) > 0 {
+			game.AnnotateInBackground(gameID)
+			w.WriteHeader(http.StatusAccepted)
+			if err := json.NewEncoder(w).Encode(map[string]interface{}{
+				"gameID": gameID,
+				"status": "pending",
+			}); err != nil {
+				log.Println("Failed to encode annotations:", err)
+			}
+			return
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"gameID": gameID,
+		"status": "ready",
+		"moves":  annotations,
+	}); err != nil {
+		log.Println("Failed to encode annotations:", err)
+	}
+}
+
+func handleEngines(w http.ResponseWriter, r *http.Request) {
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"engines":      game.Engines(),
+		"engineSizes":  game.EngineSizes(),
+		"difficulties": game.Difficulties,
+		"variants":     game.Variants(),
+		"openingBook":  game.BookVersion(),
+	}); err != nil {
+		log.Println("Failed to encode engines:", err)
+	}
+}
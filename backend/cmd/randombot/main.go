@@ -0,0 +1,54 @@
+// randombot — простейший внешний движок: на каждый go отвечает случайной пустой клеткой.
+// Пример реализации протокола из game.ExternalEngine, подключается так:
+// EXTERNAL_ENGINES="randombot=/path/to/randombot"
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	scanner := bufio.NewScanner(os.Stdin)
+	var rows []string
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "tttp":
+			fmt.Println("tttpok")
+		case "position":
+			// position <variant> <size> <winLength> <misere> <turn> <activeBoard> <rows> moves ...
+			if len(fields) < 8 {
+				fmt.Fprintln(os.Stderr, "invalid position:", scanner.Text())
+				continue
+			}
+			rows = strings.Split(fields[7], "/")
+		case "go":
+			var empty [][2]int
+			for x, row := range rows {
+				for y, cell := range row {
+					if cell == '.' {
+						empty = append(empty, [2]int{x, y})
+					}
+				}
+			}
+			if len(empty) == 0 {
+				fmt.Fprintln(os.Stderr, "no empty cells")
+				continue
+			}
+			cell := empty[rng.Intn(len(empty))]
+			fmt.Printf("bestmove %d %d\n", cell[0], cell[1])
+		case "quit":
+			return
+		}
+	}
+}
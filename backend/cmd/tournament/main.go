@@ -0,0 +1,261 @@
+// tournament — турнир ИИ-движков друг против друга без БД и websocket.
+// Каждая пара движков играет -games партий, стороны чередуются. Пример:
+//
+//	go run ./cmd/tournament -engines easy,medium,hard,perfect -games 1000
+//	go run ./cmd/tournament -engines minimax,mcts -size 15 -win 5 -games 20
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"tictactoe/game"
+)
+
+// record — результаты движка A против движка B с точки зрения A
+type record struct {
+	Wins, Draws, Losses int
+	Plies               int
+}
+
+// timing — суммарное время, которое движок потратил на ходы
+type timing struct {
+	Moves int
+	Total time.Duration
+	Max   time.Duration
+}
+
+type pairing struct {
+	A, B string
+}
+
+type gameResult struct {
+	pair   pairing
+	winner string // имя победившего движка или пустая строка при ничьей
+	plies  int
+	times  map[string]timing
+}
+
+func main() {
+	enginesFlag := flag.String("engines", "easy,medium,hard,perfect", "comma-separated engine names")
+	games := flag.Int("games", 100, "games per pairing")
+	variant := flag.String("variant", game.DefaultVariant, "rules variant")
+	size := flag.Int("size", 0, "board size (0 — variant default)")
+	winLength := flag.Int("win", 0, "marks in a row to win (0 — variant default)")
+	misere := flag.Bool("misere", false, "misere mode")
+	workers := flag.Int("workers", runtime.NumCPU(), "games played in parallel")
+	flag.Parse()
+
+	if err := game.LoadExternalEngines(os.Getenv("EXTERNAL_ENGINES")); err != nil {
+		log.Fatal("Failed to load external engines:", err)
+	}
+	opts, err := game.Options{Variant: *variant, Size: *size, WinLength: *winLength, Misere: *misere}.Normalize()
+	if err != nil {
+		log.Fatal("Invalid options:", err)
+	}
+
+	names := strings.Split(*enginesFlag, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		if !game.HasEngine(names[i]) {
+			log.Fatalf("Unknown engine %s, available: %s", names[i], strings.Join(game.Engines(), ", "))
+		}
+	}
+	if len(names) < 2 {
+		log.Fatal("At least two engines are required")
+	}
+
+	var pairings []pairing
+	for i := range names {
+		for j := i + 1; j < len(names); j++ {
+			pairings = append(pairings, pairing{names[i], names[j]})
+		}
+	}
+
+	jobs := make(chan gameResult)
+	results := make(chan gameResult)
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- play(opts, job)
+			}
+		}()
+	}
+	go func() {
+		for _, p := range pairings {
+			for i := 0; i < *games; i++ {
+				// В чётных партиях A играет X, в нечётных — O
+				job := gameResult{pair: p}
+				if i%2 == 1 {
+					job.pair = pairing{p.B, p.A}
+				}
+				jobs <- job
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	started := time.Now()
+	records := make(map[pairing]*record)
+	timings := make(map[string]*timing)
+	for _, name := range names {
+		timings[name] = &timing{}
+	}
+	played := 0
+	for r := range results {
+		played++
+		addResult(records, r)
+		for name, t := range r.times {
+			total := timings[name]
+			total.Moves += t.Moves
+			total.Total += t.Total
+			if t.Max > total.Max {
+				total.Max = t.Max
+			}
+		}
+	}
+
+	fmt.Printf("%s %dx%d, %d in a row, misere: %v — %d games in %s\n\n",
+		opts.Variant, opts.Size, opts.Size, opts.WinLength, opts.Misere, played, time.Since(started).Round(time.Millisecond))
+	printPairings(pairings, records)
+	printStandings(names, records)
+	printTimings(names, timings)
+}
+
+// play играет одну партию: pair.A за X, pair.B за O. Ошибка движка засчитывается ему поражением
+func play(opts game.Options, job gameResult) gameResult {
+	job.times = make(map[string]timing)
+	engines := make(map[string]game.Engine)
+	for symbol, name := range map[string]string{"X": job.pair.A, "O": job.pair.B} {
+		engine, err := game.NewEngine(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		engines[symbol] = engine
+	}
+
+	rules := game.RulesFor(opts)
+	pos := rules.NewPosition(opts)
+	for {
+		result := rules.Result(&pos)
+		if result.Finished {
+			switch result.Winner {
+			case "X":
+				job.winner = job.pair.A
+			case "O":
+				job.winner = job.pair.B
+			}
+			return job
+		}
+
+		engine := engines[pos.Turn]
+		start := time.Now()
+		m, _, err := engine.BestMove(&pos, pos.Turn)
+		elapsed := time.Since(start)
+		t := job.times[engine.Name()]
+		t.Moves++
+		t.Total += elapsed
+		if elapsed > t.Max {
+			t.Max = elapsed
+		}
+		job.times[engine.Name()] = t
+
+		if err == nil {
+			_, err = rules.Apply(&pos, m)
+		}
+		if err != nil {
+			log.Printf("Engine %s forfeits: %v", engine.Name(), err)
+			job.winner = job.pair.A
+			if pos.Turn == "X" {
+				job.winner = job.pair.B
+			}
+			return job
+		}
+		job.plies++
+	}
+}
+
+// addResult записывает партию в счёт пары в порядке, в котором пара объявлена в турнире
+func addResult(records map[pairing]*record, r gameResult) {
+	for _, p := range []pairing{r.pair, {r.pair.B, r.pair.A}} {
+		rec, ok := records[p]
+		if !ok {
+			rec = &record{}
+			records[p] = rec
+		}
+		rec.Plies += r.plies
+		switch r.winner {
+		case "":
+			rec.Draws++
+		case p.A:
+			rec.Wins++
+		default:
+			rec.Losses++
+		}
+	}
+}
+
+func printPairings(pairings []pairing, records map[pairing]*record) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "A\tB\tW\tD\tL\tscore\tavg plies\t")
+	for _, p := range pairings {
+		rec := records[p]
+		if rec == nil {
+			continue
+		}
+		n := rec.Wins + rec.Draws + rec.Losses
+		score := (float64(rec.Wins) + 0.5*float64(rec.Draws)) / float64(n) * 100
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%.1f%%\t%.1f\t\n", p.A, p.B, rec.Wins, rec.Draws, rec.Losses, score, float64(rec.Plies)/float64(n))
+	}
+	w.Flush()
+	fmt.Println()
+}
+
+func printStandings(names []string, records map[pairing]*record) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "engine\tW\tD\tL\tscore\t")
+	for _, name := range names {
+		total := record{}
+		for p, rec := range records {
+			if p.A == name {
+				total.Wins += rec.Wins
+				total.Draws += rec.Draws
+				total.Losses += rec.Losses
+			}
+		}
+		n := total.Wins + total.Draws + total.Losses
+		if n == 0 {
+			continue
+		}
+		score := (float64(total.Wins) + 0.5*float64(total.Draws)) / float64(n) * 100
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f%%\t\n", name, total.Wins, total.Draws, total.Losses, score)
+	}
+	w.Flush()
+	fmt.Println()
+}
+
+func printTimings(names []string, timings map[string]*timing) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "engine\tmoves\tavg/move\tmax/move\ttotal\t")
+	for _, name := range names {
+		t := timings[name]
+		if t.Moves == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t\n", name, t.Moves,
+			(t.Total / time.Duration(t.Moves)).Round(time.Microsecond), t.Max.Round(time.Microsecond), t.Total.Round(time.Millisecond))
+	}
+	w.Flush()
+}
@@ -45,6 +45,12 @@ func createTables() {
 		log.Fatal("Error creating users table:", err)
 	}
 
+	// По хешу токена игрок возвращается в следующие партии со своим рейтингом
+	_, err = DB.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS token_hash CHAR(64) UNIQUE`)
+	if err != nil {
+		log.Fatal("Error migrating users table:", err)
+	}
+
 	_, err = DB.Exec(`
 		CREATE TABLE IF NOT EXISTS games (
 			id SERIAL PRIMARY KEY,
@@ -62,6 +68,26 @@ func createTables() {
 		log.Fatal("Error creating games table:", err)
 	}
 
+	_, err = DB.Exec(`ALTER TABLE games ADD COLUMN IF NOT EXISTS difficulty VARCHAR(20)`)
+	if err != nil {
+		log.Fatal("Error migrating games table:", err)
+	}
+
+	_, err = DB.Exec(`ALTER TABLE games ADD COLUMN IF NOT EXISTS engine VARCHAR(50)`)
+	if err != nil {
+		log.Fatal("Error migrating games table:", err)
+	}
+
+	// Сколько подсказок взял каждый игрок: победы с подсказками не идут в статистику
+	_, err = DB.Exec(`
+		ALTER TABLE games
+			ADD COLUMN IF NOT EXISTS player1_hints INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS player2_hints INT NOT NULL DEFAULT 0
+	`)
+	if err != nil {
+		log.Fatal("Error migrating games table:", err)
+	}
+
 	_, err = DB.Exec(`
 		CREATE TABLE IF NOT EXISTS moves (
 			id SERIAL PRIMARY KEY,
@@ -77,6 +103,11 @@ func createTables() {
 		log.Fatal("Error creating moves table:", err)
 	}
 
+	_, err = DB.Exec(`ALTER TABLE moves ADD COLUMN IF NOT EXISTS active_board INT`)
+	if err != nil {
+		log.Fatal("Error migrating moves table:", err)
+	}
+
 	_, err = DB.Exec(`
 		CREATE TABLE IF NOT EXISTS offline_stats (
 			id SERIAL PRIMARY KEY,
@@ -90,4 +121,143 @@ func createTables() {
 	if err != nil {
 		log.Fatal("Error creating offline_stats table:", err)
 	}
-}
\ No newline at end of file
+
+	// Статистика против ИИ ведётся отдельно по каждому уровню сложности или движку. У записей,
+	// сделанных до появления уровней, difficulty остаётся NULL: против какого уровня шли те
+	// партии, неизвестно
+	_, err = DB.Exec(`ALTER TABLE offline_stats ADD COLUMN IF NOT EXISTS difficulty VARCHAR(50)`)
+	if err != nil {
+		log.Fatal("Error migrating offline_stats table:", err)
+	}
+	_, err = DB.Exec(`ALTER TABLE offline_stats ALTER COLUMN difficulty DROP NOT NULL, ALTER COLUMN difficulty DROP DEFAULT`)
+	if err != nil {
+		log.Fatal("Error migrating offline_stats table:", err)
+	}
+
+	// Раньше на игрока могло приходиться несколько строк: сливаем их до создания уникального индекса
+	if err := mergeOfflineStats(); err != nil {
+		log.Fatal("Error merging offline_stats rows:", err)
+	}
+
+	_, err = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS offline_stats_player_difficulty ON offline_stats (player_id, difficulty)`)
+	if err != nil {
+		log.Fatal("Error creating offline_stats index:", err)
+	}
+
+	// Веса ходов обучаемого ИИ MENACE по каноническим позициям
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS menace_weights (
+			position_key TEXT NOT NULL,
+			x INT NOT NULL,
+			y INT NOT NULL,
+			weight INT NOT NULL,
+			PRIMARY KEY (position_key, x, y)
+		)
+	`)
+	if err != nil {
+		log.Fatal("Error creating menace_weights table:", err)
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS menace_games (
+			game_id INT PRIMARY KEY REFERENCES games(id),
+			symbol VARCHAR(1) NOT NULL,
+			result VARCHAR(10) NOT NULL,
+			learned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Fatal("Error creating menace_games table:", err)
+	}
+
+	// Разбор ходов завершённых партий
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS move_annotations (
+			game_id INT REFERENCES games(id),
+			ply INT NOT NULL,
+			player_id INT REFERENCES users(id),
+			symbol VARCHAR(1) NOT NULL,
+			x INT NOT NULL,
+			y INT NOT NULL,
+			best_x INT NOT NULL,
+			best_y INT NOT NULL,
+			score INT NOT NULL,
+			best_score INT NOT NULL,
+			label VARCHAR(20) NOT NULL,
+			PRIMARY KEY (game_id, ply)
+		)
+	`)
+	if err != nil {
+		log.Fatal("Error creating move_annotations table:", err)
+	}
+
+	// Рейтинги Glicko-2 онлайн-игроков и их изменения после каждой партии
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS ratings (
+			player_id INT PRIMARY KEY REFERENCES users(id),
+			rating DOUBLE PRECISION NOT NULL,
+			deviation DOUBLE PRECISION NOT NULL,
+			volatility DOUBLE PRECISION NOT NULL,
+			games INT NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Fatal("Error creating ratings table:", err)
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS rating_history (
+			id SERIAL PRIMARY KEY,
+			game_id INT REFERENCES games(id),
+			player_id INT REFERENCES users(id),
+			rating_before DOUBLE PRECISION NOT NULL,
+			rating_after DOUBLE PRECISION NOT NULL,
+			deviation_after DOUBLE PRECISION NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Fatal("Error creating rating_history table:", err)
+	}
+}
+
+// mergeOfflineStats складывает строки offline_stats с одинаковыми player_id и difficulty
+// в строку с наименьшим id и удаляет остальные
+func mergeOfflineStats() error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE offline_stats s
+		SET wins = t.wins, losses = t.losses, draws = t.draws, updated_at = t.updated_at
+		FROM (
+			SELECT MIN(id) AS id,
+				COALESCE(SUM(wins), 0) AS wins,
+				COALESCE(SUM(losses), 0) AS losses,
+				COALESCE(SUM(draws), 0) AS draws,
+				MAX(updated_at) AS updated_at
+			FROM offline_stats
+			GROUP BY player_id, difficulty
+			HAVING COUNT(*) > 1
+		) t
+		WHERE s.id = t.id
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		DELETE FROM offline_stats s
+		USING offline_stats k
+		WHERE s.player_id IS NOT DISTINCT FROM k.player_id
+			AND s.difficulty IS NOT DISTINCT FROM k.difficulty
+			AND s.id > k.id
+	`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
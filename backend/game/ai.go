@@ -1,118 +1,75 @@
 package game
 
 import (
-	"math/rand"
-	"time"
+	"errors"
+	"log"
 )
 
-// MakeAIMove делает ход ИИ и возвращает координаты хода
-func (g *Game) MakeAIMove() (int, int) {
-	// Проверяем, не закончилась ли уже игра
-	if checkWinner(g.Board) != "" || isDraw(g.Board) {
-		return -1, -1
-	}
-
-	// 1. Проверяем, может ли ИИ выиграть следующим ходом
-	for i := 0; i < 3; i++ {
-		for j := 0; j < 3; j++ {
-			if g.Board[i][j] == "" {
-				g.Board[i][j] = "O"
-				if checkWinner(g.Board) == "O" {
-					g.Turn = "X"
-					return i, j
-				}
-				g.Board[i][j] = ""
-			}
-		}
-	}
+// errStaleAIMove — пока ИИ искал ход, партия изменилась или закончилась
+var errStaleAIMove = errors.New("game changed while the AI was thinking")
 
-	// 2. Проверяем, может ли игрок выиграть следующим ходом, и блокируем его
-	for i := 0; i < 3; i++ {
-		for j := 0; j < 3; j++ {
-			if g.Board[i][j] == "" {
-				g.Board[i][j] = "X"
-				if checkWinner(g.Board) == "X" {
-					g.Board[i][j] = "O" // Блокируем выигрышный ход
-					g.Turn = "X"
-					return i, j
-				}
-				g.Board[i][j] = ""
-			}
-		}
-	}
+// aiTurn — снимок оффлайн-партии, по которому ищется ход ИИ. Поиск может занять секунды,
+// поэтому он идёт по копии позиции без блокировки менеджера
+type aiTurn struct {
+	gameID int
+	pos    *Position
+	engine string
+	level  string
+	custom bool // движок выбран явно: если он сломается, доиграет движок уровня сложности
+}
 
-	// 3. Если центр свободен, занимаем его
-	if g.Board[1][1] == "" {
-		g.Board[1][1] = "O"
-		g.Turn = "X"
-		return 1, 1
+// aiTurn снимает копию позиции и настроек ИИ. Вызывается под gm.mu
+func (g *Game) aiTurn() *aiTurn {
+	return &aiTurn{
+		gameID: g.ID,
+		pos:    g.Position.Clone(),
+		engine: g.EngineName(),
+		level:  g.level(),
+		custom: g.Engine != "",
 	}
+}
 
-	// 4. Если углы свободны, занимаем случайный угол
-	corners := [][2]int{{0, 0}, {0, 2}, {2, 0}, {2, 2}}
-	availableCorners := make([][2]int, 0)
-	for _, corner := range corners {
-		if g.Board[corner[0]][corner[1]] == "" {
-			availableCorners = append(availableCorners, corner)
-		}
+// search выбирает ход ИИ за сторону, чей ход в снимке. Ход выбирает движок партии
+// из реестра (см. EngineName)
+func (t *aiTurn) search() (Move, error) {
+	engine, err := NewEngine(t.engine)
+	if err != nil {
+		return Move{}, err
 	}
-	if len(availableCorners) > 0 {
-		rand.Seed(time.Now().UnixNano())
-		corner := availableCorners[rand.Intn(len(availableCorners))]
-		g.Board[corner[0]][corner[1]] = "O"
-		g.Turn = "X"
-		return corner[0], corner[1]
-	}
-
-	// 5. Занимаем любую свободную клетку
-	for i := 0; i < 3; i++ {
-		for j := 0; j < 3; j++ {
-			if g.Board[i][j] == "" {
-				g.Board[i][j] = "O"
-				g.Turn = "X"
-				return i, j
-			}
-		}
+	m, _, err := engine.BestMove(t.pos, t.pos.Turn)
+	// Если выбранный движок сломался, партию доигрывает встроенный ИИ уровня сложности
+	if err != nil && err != ErrNoMoves && t.custom {
+		log.Printf("Engine %s failed in game %d: %v, falling back to %s", engine.Name(), t.gameID, err, t.level)
+		m, _, err = difficultyEngine{level: t.level}.BestMove(t.pos, t.pos.Turn)
 	}
-
-	return -1, -1
+	return m, err
 }
 
-// checkWinner проверяет, есть ли победитель
-func checkWinner(board Board) string {
-	// Проверка строк
-	for i := 0; i < 3; i++ {
-		if board[i][0] != "" && board[i][0] == board[i][1] && board[i][1] == board[i][2] {
-			return board[i][0]
-		}
+// playAIMove применяет найденный ход, если с момента снимка в партии не было ходов
+func (g *Game) playAIMove(t *aiTurn, m Move) (Move, error) {
+	if !g.IsAITurn() || g.Turn != t.pos.Turn || len(g.History) != len(t.pos.History) {
+		return Move{}, errStaleAIMove
 	}
+	return g.Rules().Apply(&g.Position, m)
+}
 
-	// Проверка столбцов
-	for i := 0; i < 3; i++ {
-		if board[0][i] != "" && board[0][i] == board[1][i] && board[1][i] == board[2][i] {
-			return board[0][i]
-		}
+// EngineName возвращает имя движка, который играет за ИИ: явно выбранный движок
+// или движок уровня сложности
+func (g *Game) EngineName() string {
+	if g.Engine != "" {
+		return g.Engine
 	}
+	return g.level()
+}
 
-	// Проверка диагоналей
-	if board[0][0] != "" && board[0][0] == board[1][1] && board[1][1] == board[2][2] {
-		return board[0][0]
-	}
-	if board[0][2] != "" && board[0][2] == board[1][1] && board[1][1] == board[2][0] {
-		return board[0][2]
+func (g *Game) level() string {
+	if g.Difficulty != "" {
+		return g.Difficulty
 	}
-
-	return ""
+	return DefaultDifficulty
 }
 
-// isDraw проверяет, есть ли ничья
-func isDraw(board Board) bool {
-	for i := 0; i < 3; i++ {
-		for j := 0; j < 3; j++ {
-			if board[i][j] == "" {
-				return false
-			}
-		}
-	}
-	return true
-}
\ No newline at end of file
+// IsAITurn сообщает, что в активной оффлайн-партии сейчас очередь ИИ
+func (g *Game) IsAITurn() bool {
+	return g.Player2ID == 0 && g.Status == "active" && g.Turn == g.AISymbol
+}
@@ -0,0 +1,147 @@
+package game
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// AnalysisTimeBudget — сколько времени Analyze тратит на одну позицию
+const AnalysisTimeBudget = 2 * time.Second
+
+// MoveValue — оценка хода с точки зрения стороны, которая его делает
+type MoveValue struct {
+	Move
+	Evaluation
+}
+
+// Analysis — разбор позиции для стороны, чей ход
+type Analysis struct {
+	Turn     string `json:"turn"`
+	Finished bool   `json:"finished"`
+	Winner   string `json:"winner,omitempty"`
+	// Exact — перебор дошёл до конца партии, и Outcome — теоретический результат
+	Exact bool `json:"exact"`
+	Depth int  `json:"depth,omitempty"`
+	// TimedOut — время кончилось раньше, чем поиск дошёл до DefaultSearchDepth, и оценки
+	// взяты с последней полностью просчитанной глубины Depth
+	TimedOut bool `json:"timedOut,omitempty"`
+	Evaluation
+	Moves              []MoveValue `json:"moves"`
+	PrincipalVariation []Move      `json:"principalVariation"`
+}
+
+// Analyze оценивает ходы и строит главный вариант — последовательность лучших ходов
+// обеих сторон. На больших досках оцениваются только клетки рядом с занятыми, поиск ограничен
+// по глубине (DefaultSearchDepth), и Outcome заполняется, только если результат форсирован
+// в пределах этой глубины. Глубина наращивается постепенно, пока не кончится AnalysisTimeBudget
+func Analyze(p *Position) *Analysis {
+	rules := p.Rules()
+	a := &Analysis{Turn: p.Turn, Moves: make([]MoveValue, 0), PrincipalVariation: make([]Move, 0)}
+	if result := rules.Result(p); result.Finished {
+		a.Finished, a.Winner = true, result.Winner
+		return a
+	}
+
+	search := NewMinimax(0)
+	search.Deadline = time.Now().Add(AnalysisTimeBudget)
+	for _, depth := range deepening(DefaultSearchDepth(p)) {
+		search.MaxDepth = depth
+		values := search.Evaluate(p)
+		if search.TimedOut() {
+			a.TimedOut = true
+			break
+		}
+		a.Depth, a.Exact = depth, depth == 0
+		a.Moves = a.Moves[:0]
+		for m, score := range values {
+			a.Moves = append(a.Moves, MoveValue{Move: m, Evaluation: *scoreEvaluation(score, a.Exact)})
+		}
+	}
+	if len(a.Moves) == 0 {
+		return a
+	}
+	sort.Slice(a.Moves, func(i, j int) bool {
+		if a.Moves[i].Score != a.Moves[j].Score {
+			return a.Moves[i].Score > a.Moves[j].Score
+		}
+		if a.Moves[i].X != a.Moves[j].X {
+			return a.Moves[i].X < a.Moves[j].X
+		}
+		return a.Moves[i].Y < a.Moves[j].Y
+	})
+	a.Evaluation = a.Moves[0].Evaluation
+
+	// Главный вариант: за каждую сторону по очереди лучший ход, пока партия не кончится,
+	// не исчерпается глубина поиска или время
+	pos := p.Clone()
+	for ply := 0; a.Depth == 0 || ply < a.Depth; ply++ {
+		if rules.Result(pos).Finished {
+			break
+		}
+		search.MaxDepth = 0
+		if a.Depth != 0 {
+			search.MaxDepth = a.Depth - ply
+		}
+		m, _, ok := search.Search(pos)
+		if !ok {
+			break
+		}
+		applied, err := rules.Apply(pos, m)
+		if err != nil {
+			break
+		}
+		a.PrincipalVariation = append(a.PrincipalVariation, applied)
+	}
+	return a
+}
+
+// PositionFromBoard строит позицию по клеткам доски без истории ходов. Очередь хода,
+// если не указана, определяется по числу символов. Для rolling история важна, поэтому
+// такую позицию лучше задавать ходами
+func PositionFromBoard(opts Options, board Board, turn string, activeBoard int) (*Position, error) {
+	opts, err := opts.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	if len(board) != opts.Size {
+		return nil, errors.New("board size does not match size")
+	}
+	xCount, oCount := 0, 0
+	for _, row := range board {
+		if len(row) != opts.Size {
+			return nil, errors.New("board size does not match size")
+		}
+		for _, cell := range row {
+			switch cell {
+			case "":
+			case "X":
+				xCount++
+			case "O":
+				oCount++
+			default:
+				return nil, errors.New("cells must be \"\", \"X\" or \"O\"")
+			}
+		}
+	}
+	if turn == "" {
+		turn = "X"
+		if xCount > oCount {
+			turn = "O"
+		}
+	}
+	if turn != "X" && turn != "O" {
+		return nil, errors.New("turn must be X or O")
+	}
+
+	p := RulesFor(opts).NewPosition(opts)
+	p.Board = board.Clone()
+	p.Turn = turn
+	if opts.Variant == "ultimate" {
+		if activeBoard < AnyBoard || activeBoard > 8 {
+			return nil, errors.New("activeBoard must be between -1 and 8")
+		}
+		p.ActiveBoard = activeBoard
+	}
+	return &p, nil
+}
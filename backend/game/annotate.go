@@ -0,0 +1,282 @@
+package game
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"tictactoe/db"
+)
+
+const (
+	AnnotationBest       = "best"
+	AnnotationGood       = "good"
+	AnnotationInaccuracy = "inaccuracy"
+	AnnotationBlunder    = "blunder"
+	AnnotationMissedWin  = "missed win"
+
+	// inaccuracyMargin — на сколько эвристическая оценка хода может уступать лучшему,
+	// чтобы ход ещё считался хорошим, а не неточностью
+	inaccuracyMargin = 50
+
+	// AnnotationTimeBudget — сколько времени разбор тратит на один ход партии
+	AnnotationTimeBudget = 250 * time.Millisecond
+	// maxConcurrentAnnotations — сколько партий разбирается одновременно, остальные ждут очереди
+	maxConcurrentAnnotations = 2
+)
+
+// Annotation — разбор одного хода партии: что сыграно, что было лучше и насколько это важно.
+// Оценки — с точки зрения сделавшего ход
+type Annotation struct {
+	Ply       int    `json:"ply"`
+	PlayerID  int    `json:"playerID,omitempty"` // 0 — ход ИИ
+	Symbol    string `json:"symbol"`
+	Move      Move   `json:"move"`
+	BestMove  Move   `json:"bestMove"`
+	Score     int    `json:"score"`
+	BestScore int    `json:"bestScore"`
+	Label     string `json:"label"`
+}
+
+// moveRecord — ход из таблицы moves вместе с тем, кто его сделал
+type moveRecord struct {
+	Move
+	PlayerID int // 0 — ход ИИ
+	Symbol   string
+}
+
+// AnnotateGame разбирает каждый ход сохранённой партии
+func AnnotateGame(gameID int) ([]Annotation, error) {
+	opts, records, err := loadMoveRecords(gameID)
+	if err != nil {
+		return nil, err
+	}
+	return annotateMoves(opts, records)
+}
+
+func annotateMoves(opts Options, records []moveRecord) ([]Annotation, error) {
+	opts, err := opts.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	rules := RulesFor(opts)
+	p := rules.NewPosition(opts)
+	search := NewMinimax(0)
+
+	annotations := make([]Annotation, 0, len(records))
+	for i, record := range records {
+		values, depth := evaluateWithin(search, &p)
+		played, ok := values[record.Move]
+		if !ok {
+			// На больших досках Evaluate смотрит только клетки рядом с занятыми
+			played = moveScore(search, rules, &p, record.Move, depth)
+		}
+
+		best, bestScore := record.Move, played
+		for _, m := range candidateMoves(rules, &p) {
+			if v, ok := values[m]; ok && v > bestScore {
+				best, bestScore = m, v
+			}
+		}
+		annotations = append(annotations, Annotation{
+			Ply:       i + 1,
+			PlayerID:  record.PlayerID,
+			Symbol:    record.Symbol,
+			Move:      record.Move,
+			BestMove:  best,
+			Score:     played,
+			BestScore: bestScore,
+			Label:     annotationLabel(played, bestScore),
+		})
+
+		if _, err := rules.Apply(&p, record.Move); err != nil {
+			return annotations, err
+		}
+	}
+	return annotations, nil
+}
+
+// evaluateWithin оценивает ходы, наращивая глубину, пока не кончится AnnotationTimeBudget, и
+// возвращает оценки с последней полностью просчитанной глубины. Если не успела даже первая,
+// ходы оцениваются на один полуход без ограничения времени — это быстро
+func evaluateWithin(search *Minimax, p *Position) (map[Move]int, int) {
+	search.Deadline = time.Now().Add(AnnotationTimeBudget)
+	var values map[Move]int
+	depth := 1
+	for _, d := range deepening(DefaultSearchDepth(p)) {
+		search.MaxDepth = d
+		v := search.Evaluate(p)
+		if search.TimedOut() {
+			break
+		}
+		values, depth = v, d
+	}
+	if values == nil {
+		search.Deadline, search.MaxDepth = time.Time{}, 1
+		values = search.Evaluate(p)
+	}
+	return values, depth
+}
+
+// moveScore оценивает один ход на глубине depth за AnnotationTimeBudget, а не успев —
+// на один полуход
+func moveScore(search *Minimax, rules Ruleset, p *Position, m Move, depth int) int {
+	child := p.Clone()
+	if _, err := rules.Apply(child, m); err != nil {
+		return 0
+	}
+	search.reset()
+	search.Deadline, search.MaxDepth = time.Now().Add(AnnotationTimeBudget), depth
+	score := -search.negamax(rules, child, 1, -WinScore-1, WinScore+1)
+	if search.TimedOut() {
+		search.reset()
+		search.Deadline, search.MaxDepth = time.Time{}, 1
+		score = -search.negamax(rules, child, 1, -WinScore-1, WinScore+1)
+	}
+	return score
+}
+
+// annotationLabel сравнивает сыгранный ход с лучшим: потеря форсированного результата —
+// зевок или упущенная победа, потеря в эвристической оценке — неточность
+func annotationLabel(played, best int) string {
+	playedOutcome, bestOutcome := scoreOutcome(played), scoreOutcome(best)
+	switch {
+	case played >= best:
+		return AnnotationBest
+	case playedOutcome == bestOutcome:
+		if bestOutcome == 0 && best-played > inaccuracyMargin {
+			return AnnotationInaccuracy
+		}
+		return AnnotationGood
+	case bestOutcome > 0 && playedOutcome == 0:
+		return AnnotationMissedWin
+	default:
+		return AnnotationBlunder
+	}
+}
+
+// scoreOutcome: 1 — форсированный выигрыш, -1 — форсированный проигрыш, 0 — иначе
+func scoreOutcome(score int) int {
+	switch {
+	case score > winThreshold:
+		return 1
+	case score < -winThreshold:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// SaveAnnotations заменяет сохранённый разбор партии. Если разбор той же партии параллельно
+// сохраняет другой запрос, уже записанные им ходы остаются как есть
+func SaveAnnotations(gameID int, annotations []Annotation) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM move_annotations WHERE game_id = $1", gameID); err != nil {
+		return err
+	}
+	for _, a := range annotations {
+		_, err := tx.Exec(
+			"INSERT INTO move_annotations (game_id, ply, player_id, symbol, x, y, best_x, best_y, score, best_score, label) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) "+
+				"ON CONFLICT (game_id, ply) DO NOTHING",
+			gameID, a.Ply, nullableID(a.PlayerID), a.Symbol, a.Move.X, a.Move.Y, a.BestMove.X, a.BestMove.Y, a.Score, a.BestScore, a.Label,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadAnnotations читает сохранённый разбор партии по порядку ходов
+func LoadAnnotations(gameID int) ([]Annotation, error) {
+	rows, err := db.DB.Query(
+		"SELECT ply, player_id, symbol, x, y, best_x, best_y, score, best_score, label FROM move_annotations WHERE game_id = $1 ORDER BY ply",
+		gameID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	annotations := make([]Annotation, 0)
+	for rows.Next() {
+		var a Annotation
+		var playerID sql.NullInt64
+		if err := rows.Scan(&a.Ply, &playerID, &a.Symbol, &a.Move.X, &a.Move.Y, &a.BestMove.X, &a.BestMove.Y, &a.Score, &a.BestScore, &a.Label); err != nil {
+			return nil, err
+		}
+		a.PlayerID = int(playerID.Int64)
+		annotations = append(annotations, a)
+	}
+	return annotations, rows.Err()
+}
+
+var (
+	annotationMu      sync.Mutex
+	annotationPending = make(map[int]bool)
+	// annotationSlots ограничивает число одновременных разборов: каждый занимает ядро
+	// до AnnotationTimeBudget на ход
+	annotationSlots = make(chan struct{}, maxConcurrentAnnotations)
+)
+
+// AnnotateInBackground разбирает завершённую партию и сохраняет разбор, не задерживая
+// вызывающего. Если партию уже разбирают, второй разбор не начинается
+func AnnotateInBackground(gameID int) {
+	annotationMu.Lock()
+	if annotationPending[gameID] {
+		annotationMu.Unlock()
+		return
+	}
+	annotationPending[gameID] = true
+	annotationMu.Unlock()
+
+	go func() {
+		defer func() {
+			annotationMu.Lock()
+			delete(annotationPending, gameID)
+			annotationMu.Unlock()
+		}()
+
+		annotationSlots <- struct{}{}
+		defer func() { <-annotationSlots }()
+
+		annotations, err := AnnotateGame(gameID)
+		if err == nil {
+			err = SaveAnnotations(gameID, annotations)
+		}
+		if err != nil {
+			log.Printf("Failed to annotate game %d: %v", gameID, err)
+		}
+	}()
+}
+
+// loadMoveRecords читает параметры и ходы партии вместе с тем, кто их сделал
+func loadMoveRecords(gameID int) (Options, []moveRecord, error) {
+	opts, _, err := LoadGameMoves(gameID)
+	if err != nil {
+		return opts, nil, err
+	}
+	rows, err := db.DB.Query("SELECT x, y, player_id, symbol FROM moves WHERE game_id = $1 ORDER BY id", gameID)
+	if err != nil {
+		return opts, nil, err
+	}
+	defer rows.Close()
+
+	records := make([]moveRecord, 0)
+	for rows.Next() {
+		var r moveRecord
+		var playerID sql.NullInt64
+		if err := rows.Scan(&r.X, &r.Y, &playerID, &r.Symbol); err != nil {
+			return opts, nil, err
+		}
+		r.PlayerID = int(playerID.Int64)
+		records = append(records, r)
+	}
+	return opts, records, rows.Err()
+}
@@ -0,0 +1,43 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnnotateMovesTimeBudget(t *testing.T) {
+	records := []moveRecord{
+		{Move: Move{X: 9, Y: 9}, Symbol: "X"}, {Move: Move{X: 9, Y: 10}, Symbol: "O"},
+		{Move: Move{X: 10, Y: 9}, Symbol: "X"}, {Move: Move{X: 8, Y: 8}, Symbol: "O"},
+		{Move: Move{X: 11, Y: 9}, Symbol: "X"}, {Move: Move{X: 0, Y: 0}, Symbol: "O"},
+	}
+	start := time.Now()
+	annotations, err := annotateMoves(Options{Size: 19, WinLength: 5}, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != len(records) {
+		t.Fatalf("got %d annotations, want %d", len(annotations), len(records))
+	}
+	// На каждый ход — Evaluate и, для хода вдали от занятых клеток, отдельная оценка
+	if limit := time.Duration(2*len(records))*AnnotationTimeBudget + time.Second; time.Since(start) > limit {
+		t.Fatalf("annotation took %s, want at most %s", time.Since(start), limit)
+	}
+}
+
+func TestAnnotateMovesSlowerWin(t *testing.T) {
+	// X может выиграть сразу, но ставит вилку и выигрывает на ход позже — это не лучший ход
+	records := []moveRecord{
+		{Move: Move{X: 0, Y: 0}, Symbol: "X"}, {Move: Move{X: 1, Y: 1}, Symbol: "O"},
+		{Move: Move{X: 0, Y: 1}, Symbol: "X"}, {Move: Move{X: 2, Y: 2}, Symbol: "O"},
+		{Move: Move{X: 2, Y: 0}, Symbol: "X"},
+	}
+	annotations, err := annotateMoves(Options{Size: 3}, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := annotations[len(annotations)-1]
+	if last.Label == AnnotationBest {
+		t.Fatalf("move %v labelled %q, want a worse label (best was %v)", last.Move, last.Label, last.BestMove)
+	}
+}
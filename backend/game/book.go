@@ -0,0 +1,155 @@
+package game
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+)
+
+// bookMaxPlies — после стольких ходов книга больше не проверяется
+const bookMaxPlies = 6
+
+//go:embed openings.json
+var openingsJSON []byte
+
+// bookFile — формат openings.json: позиция задаётся ходами от начала партии,
+// ответы — ходами с весами, чем больше вес, тем чаще ход выбирается
+type bookFile struct {
+	Version  int `json:"version"`
+	Openings []struct {
+		Variant   string `json:"variant"`
+		Size      int    `json:"size"`
+		WinLength int    `json:"winLength"`
+		Misere    bool   `json:"misere"`
+		Moves     []Move `json:"moves"`
+		Replies   []struct {
+			Move
+			Weight int `json:"weight"`
+		} `json:"replies"`
+	} `json:"openings"`
+}
+
+// OpeningBook — дебютная книга. Позиции и ответы хранятся по каноническим ключам,
+// поэтому книга срабатывает и в симметричных позициях, а из равноценных симметричных
+// ходов выбирается случайный
+type OpeningBook struct {
+	Version   int
+	positions map[string]map[string]int // ключ позиции -> ключ позиции после ответа -> вес
+}
+
+var (
+	bookOnce    sync.Once
+	defaultBook *OpeningBook
+)
+
+// openingBook загружает встроенную книгу при первом обращении: правила вариантов
+// регистрируются в init, поэтому раньше разобрать книгу нельзя
+func openingBook() *OpeningBook {
+	bookOnce.Do(func() {
+		book, err := LoadOpeningBook(openingsJSON)
+		if err != nil {
+			log.Printf("Opening book disabled: %v", err)
+			book = &OpeningBook{positions: make(map[string]map[string]int)}
+		}
+		defaultBook = book
+	})
+	return defaultBook
+}
+
+// BookVersion возвращает версию встроенной дебютной книги, 0 — книга не загрузилась
+func BookVersion() int {
+	return openingBook().Version
+}
+
+// LoadOpeningBook разбирает книгу и проверяет, что все ходы в ней допустимы
+func LoadOpeningBook(data []byte) (*OpeningBook, error) {
+	var file bookFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	book := &OpeningBook{Version: file.Version, positions: make(map[string]map[string]int)}
+	for i, opening := range file.Openings {
+		opts := Options{Variant: opening.Variant, Size: opening.Size, WinLength: opening.WinLength, Misere: opening.Misere}
+		p, err := Replay(opts, opening.Moves)
+		if err != nil {
+			return nil, fmt.Errorf("opening %d: %v", i+1, err)
+		}
+		rules := p.Rules()
+		key, _ := optionsKey(rules, p)
+		replies, ok := book.positions[key]
+		if !ok {
+			replies = make(map[string]int)
+			book.positions[key] = replies
+		}
+		for _, reply := range opening.Replies {
+			child := p.Clone()
+			if _, err := rules.Apply(child, reply.Move); err != nil {
+				return nil, fmt.Errorf("opening %d reply [%d,%d]: %v", i+1, reply.X, reply.Y, err)
+			}
+			childKey, _ := optionsKey(rules, child)
+			replies[childKey] += reply.Weight
+		}
+	}
+	return book, nil
+}
+
+// Move возвращает книжный ход для стороны p.Turn или ok == false, если позиции нет в книге
+func (b *OpeningBook) Move(p *Position) (Move, bool) {
+	if len(p.History) >= bookMaxPlies {
+		return Move{}, false
+	}
+	rules := p.Rules()
+	key, _ := optionsKey(rules, p)
+	replies, ok := b.positions[key]
+	if !ok {
+		return Move{}, false
+	}
+
+	// Ходы, ведущие в одну и ту же позицию с точностью до симметрии, делят один вес
+	classes := make(map[string][]Move)
+	var order []string
+	total := 0
+	for _, m := range rules.LegalMoves(p) {
+		child := p.Clone()
+		if _, err := rules.Apply(child, m); err != nil {
+			continue
+		}
+		childKey, _ := optionsKey(rules, child)
+		if replies[childKey] <= 0 {
+			continue
+		}
+		if _, seen := classes[childKey]; !seen {
+			order = append(order, childKey)
+			total += replies[childKey]
+		}
+		classes[childKey] = append(classes[childKey], m)
+	}
+	if total == 0 {
+		return Move{}, false
+	}
+
+	pick := rand.Intn(total)
+	for _, childKey := range order {
+		if pick < replies[childKey] {
+			moves := classes[childKey]
+			return moves[rand.Intn(len(moves))], true
+		}
+		pick -= replies[childKey]
+	}
+	return Move{}, false
+}
+
+// bookEngine играет по дебютной книге, пока позиция в ней есть, а дальше передаёт ход движку
+type bookEngine struct {
+	Engine
+}
+
+func (e bookEngine) BestMove(p *Position, side string) (Move, *Evaluation, error) {
+	if m, ok := openingBook().Move(positionFor(p, side)); ok {
+		return m, nil, nil
+	}
+	return e.Engine.BestMove(p, side)
+}
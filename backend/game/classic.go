@@ -0,0 +1,62 @@
+package game
+
+// classicRules — обычные крестики-нолики на доске N×N до K в ряд
+type classicRules struct{}
+
+func init() {
+	RegisterRuleset(classicRules{})
+}
+
+func (classicRules) Name() string {
+	return "classic"
+}
+
+func (classicRules) Normalize(opts Options) (Options, error) {
+	if opts.Size == 0 {
+		opts.Size = DefaultSize
+	}
+	if opts.WinLength == 0 {
+		opts.WinLength = DefaultWinLength
+	}
+	return opts, opts.Validate()
+}
+
+func (classicRules) NewPosition(opts Options) Position {
+	return Position{Options: opts, Board: NewBoard(opts.Size), Turn: "X", ActiveBoard: AnyBoard}
+}
+
+func (r classicRules) LegalMoves(p *Position) []Move {
+	if r.Result(p).Finished {
+		return nil
+	}
+	moves := make([]Move, 0)
+	for i := range p.Board {
+		for j := range p.Board[i] {
+			if p.Board[i][j] == "" {
+				moves = append(moves, Move{X: i, Y: j})
+			}
+		}
+	}
+	return moves
+}
+
+func (r classicRules) Apply(p *Position, m Move) (Move, error) {
+	if r.Result(p).Finished {
+		return m, ErrGameFinished
+	}
+	if !p.Board.InBounds(m.X, m.Y) {
+		return m, ErrInvalidCoordinates
+	}
+	if p.Board[m.X][m.Y] != "" {
+		return m, ErrCellOccupied
+	}
+	p.place(m)
+	return m, nil
+}
+
+func (classicRules) Result(p *Position) Result {
+	if winner := p.Board.CheckWinner(p.WinLength); winner != "" {
+		return Result{Finished: true, Winner: winner}
+	}
+	return Result{Finished: p.Board.IsFull()}
+}
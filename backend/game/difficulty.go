@@ -0,0 +1,103 @@
+package game
+
+import "time"
+
+const (
+	DifficultyEasy    = "easy"
+	DifficultyMedium  = "medium"
+	DifficultyHard    = "hard"
+	DifficultyPerfect = "perfect"
+
+	DefaultDifficulty = DifficultyMedium
+
+	// hardMistakeChance — как часто hard ошибается на досках, которые перебираются полностью
+	hardMistakeChance = 0.1
+	// botDeviation — отклонение условного рейтинга ИИ: его сила известна
+	botDeviation = 50.0
+)
+
+var Difficulties = []string{DifficultyEasy, DifficultyMedium, DifficultyHard, DifficultyPerfect}
+
+func ValidDifficulty(difficulty string) bool {
+	for _, d := range Difficulties {
+		if d == difficulty {
+			return true
+		}
+	}
+	return false
+}
+
+// DifficultyForRating подбирает уровень ИИ под рейтинг игрока
+func DifficultyForRating(rating float64) string {
+	switch {
+	case rating < 1400:
+		return DifficultyEasy
+	case rating < 1600:
+		return DifficultyMedium
+	case rating < 1800:
+		return DifficultyHard
+	default:
+		return DifficultyPerfect
+	}
+}
+
+// BotPlayerID — ID, под которым ИИ уровня difficulty показан в game_start партии из очереди.
+// Он одинаковый во всех партиях уровня и отрицательный, чтобы не совпасть с ID игроков
+func BotPlayerID(difficulty string) int {
+	for i, d := range Difficulties {
+		if d == difficulty {
+			return -(i + 1)
+		}
+	}
+	return 0
+}
+
+// BotRating — условный рейтинг ИИ уровня difficulty: середина диапазона, для которого
+// уровень выбирает DifficultyForRating. В настоящих рейтингах он не участвует
+func BotRating(difficulty string) Rating {
+	rating := map[string]float64{
+		DifficultyEasy:    1300,
+		DifficultyMedium:  1500,
+		DifficultyHard:    1700,
+		DifficultyPerfect: 1900,
+	}[difficulty]
+	if rating == 0 {
+		rating = DefaultRating
+	}
+	return Rating{Rating: rating, Deviation: botDeviation, Volatility: DefaultVolatility}
+}
+
+// DifficultySearchDepth возвращает глубину поиска Minimax для уровня сложности.
+// На perfect используется DefaultSearchDepth, остальные уровни смотрят на меньшее число ходов вперёд
+func DifficultySearchDepth(difficulty string, p *Position) int {
+	perfect := DefaultSearchDepth(p)
+	switch difficulty {
+	case DifficultyEasy:
+		return 1
+	case DifficultyMedium:
+		return 2
+	case DifficultyHard:
+		if perfect == 0 {
+			return 4
+		}
+		if perfect > 2 {
+			return perfect - 1
+		}
+		return 2
+	default:
+		return perfect
+	}
+}
+
+// DifficultyMCTSTime возвращает время на ход для MCTS на больших досках.
+// Нулевое значение означает, что на этом уровне используется Minimax
+func DifficultyMCTSTime(difficulty string) time.Duration {
+	switch difficulty {
+	case DifficultyEasy, DifficultyMedium:
+		return 0
+	case DifficultyHard:
+		return 500 * time.Millisecond
+	default:
+		return 1500 * time.Millisecond
+	}
+}
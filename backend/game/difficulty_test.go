@@ -0,0 +1,19 @@
+package game
+
+import "testing"
+
+func TestBotIdentity(t *testing.T) {
+	seen := make(map[int]bool)
+	for _, difficulty := range Difficulties {
+		id := BotPlayerID(difficulty)
+		if id >= 0 || seen[id] {
+			t.Errorf("%s: bot ID %d, want a distinct negative ID", difficulty, id)
+		}
+		seen[id] = true
+
+		// Условный рейтинг бота попадает в диапазон, для которого выбирается его уровень
+		if got := DifficultyForRating(BotRating(difficulty).Rating); got != difficulty {
+			t.Errorf("%s: bot rating %.0f maps to %s", difficulty, BotRating(difficulty).Rating, got)
+		}
+	}
+}
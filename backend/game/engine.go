@@ -0,0 +1,248 @@
+package game
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+var ErrNoMoves = errors.New("No available moves")
+
+// Evaluation — необязательная оценка хода, которую движок возвращает вместе с ним
+type Evaluation struct {
+	Score   int     `json:"score"`             // с точки зрения стороны, за которую искали ход
+	Outcome string  `json:"outcome,omitempty"` // "win", "draw" или "loss", если результат форсирован
+	WinRate float64 `json:"winRate,omitempty"` // доля выигрышей в доигрываниях MCTS
+}
+
+// Engine — ИИ, который по позиции и стороне выбирает ход
+type Engine interface {
+	Name() string
+	BestMove(p *Position, side string) (Move, *Evaluation, error)
+}
+
+// SizeLimited — необязательный интерфейс движков, которые играют не на всех досках
+type SizeLimited interface {
+	Supports(opts Options) bool
+}
+
+// EngineFactory создаёт движок. Движки с состоянием (таблицы, процессы) могут возвращать общий экземпляр
+type EngineFactory func() Engine
+
+var engines = make(map[string]EngineFactory)
+
+func RegisterEngine(name string, factory EngineFactory) {
+	engines[name] = factory
+}
+
+func NewEngine(name string) (Engine, error) {
+	factory, ok := engines[name]
+	if !ok {
+		return nil, errors.New("unknown engine: " + name)
+	}
+	return factory(), nil
+}
+
+func HasEngine(name string) bool {
+	_, ok := engines[name]
+	return ok
+}
+
+// EngineSupports сообщает, играет ли движок name партию с параметрами opts
+func EngineSupports(name string, opts Options) bool {
+	factory, ok := engines[name]
+	if !ok {
+		return false
+	}
+	if limited, ok := factory().(SizeLimited); ok {
+		return limited.Supports(opts)
+	}
+	return true
+}
+
+// EngineSizes возвращает для движков, которые играют не на всех досках, размеры
+// классической доски, на которых они играют
+func EngineSizes() map[string][]int {
+	sizes := make(map[string][]int)
+	for name, factory := range engines {
+		limited, ok := factory().(SizeLimited)
+		if !ok {
+			continue
+		}
+		supported := make([]int, 0)
+		for size := MinSize; size <= MaxSize; size++ {
+			if limited.Supports(Options{Variant: DefaultVariant, Size: size, WinLength: DefaultWinLength}) {
+				supported = append(supported, size)
+			}
+		}
+		sizes[name] = supported
+	}
+	return sizes
+}
+
+// Engines возвращает имена всех зарегистрированных движков
+func Engines() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterEngine("random", func() Engine { return randomEngine{} })
+	RegisterEngine("minimax", func() Engine { return bookEngine{NewMinimax(0)} })
+	RegisterEngine("mcts", func() Engine { return bookEngine{NewMCTS(0, DifficultyMCTSTime(DifficultyPerfect), 0)} })
+	for _, difficulty := range Difficulties {
+		level := difficulty
+		if level == DifficultyHard {
+			// На маленьких досках поиск любой глубины играет безошибочно, и hard ошибается нарочно
+			RegisterEngine(level, func() Engine {
+				return subtleMistakes{bookEngine{difficultyEngine{level: level}}, hardMistakeChance}
+			})
+			continue
+		}
+		RegisterEngine(level, func() Engine { return bookEngine{difficultyEngine{level: level}} })
+	}
+}
+
+// positionFor возвращает копию позиции, в которой ходит side
+func positionFor(p *Position, side string) *Position {
+	c := p.Clone()
+	c.Turn = side
+	return c
+}
+
+// randomEngine делает случайный допустимый ход
+type randomEngine struct{}
+
+func (randomEngine) Name() string {
+	return "random"
+}
+
+func (randomEngine) BestMove(p *Position, side string) (Move, *Evaluation, error) {
+	moves := p.Rules().LegalMoves(positionFor(p, side))
+	if len(moves) == 0 {
+		return Move{}, nil, ErrNoMoves
+	}
+	return moves[rand.Intn(len(moves))], nil, nil
+}
+
+func (s *Minimax) Name() string {
+	return "minimax"
+}
+
+// BestMove ищет ход Minimax. Если MaxDepth не задан, глубина выбирается по DefaultSearchDepth
+func (s *Minimax) BestMove(p *Position, side string) (Move, *Evaluation, error) {
+	pos := positionFor(p, side)
+	search := s
+	if s.MaxDepth == 0 && DefaultSearchDepth(pos) != 0 {
+		search = &Minimax{MaxDepth: DefaultSearchDepth(pos), table: s.table}
+	}
+	m, score, ok := search.Search(pos)
+	if !ok {
+		return Move{}, nil, ErrNoMoves
+	}
+	return m, scoreEvaluation(score, search.MaxDepth == 0), nil
+}
+
+func (s *MCTS) Name() string {
+	return "mcts"
+}
+
+func (s *MCTS) BestMove(p *Position, side string) (Move, *Evaluation, error) {
+	m, winRate, ok := s.Search(positionFor(p, side))
+	if !ok {
+		return Move{}, nil, ErrNoMoves
+	}
+	return m, &Evaluation{WinRate: winRate}, nil
+}
+
+// difficultyEngine — ИИ уровня сложности: Minimax с глубиной по уровню, MCTS на больших
+// досках для hard и perfect, на лёгком уровне — HumanLike, который иногда ошибается
+type difficultyEngine struct {
+	level string
+}
+
+func (e difficultyEngine) Name() string {
+	return e.level
+}
+
+func (e difficultyEngine) BestMove(p *Position, side string) (Move, *Evaluation, error) {
+	if e.level == DifficultyEasy {
+		return NewHumanLike().BestMove(p, side)
+	}
+	if timeLimit := DifficultyMCTSTime(e.level); timeLimit > 0 && p.Board.Size()*p.Board.Size() > mctsLargeBoard {
+		return NewMCTS(0, timeLimit, time.Now().UnixNano()).BestMove(p, side)
+	}
+	return NewMinimax(DifficultySearchDepth(e.level, p)).BestMove(p, side)
+}
+
+// subtleMistakes — с вероятностью Chance вместо хода Engine делает ошибку, которую наказывает
+// только точная игра: после неё результат при точной игре хуже, но ответ поиском глубины 2
+// (уровень medium) этим не пользуется. Ошибается только на досках, которые перебираются
+// полностью (DefaultSearchDepth == 0), — на остальных ошибки и так даёт ограниченная глубина
+type subtleMistakes struct {
+	Engine
+	Chance float64
+}
+
+func (e subtleMistakes) BestMove(p *Position, side string) (Move, *Evaluation, error) {
+	pos := positionFor(p, side)
+	if DefaultSearchDepth(pos) != 0 || rand.Float64() >= e.Chance {
+		return e.Engine.BestMove(p, side)
+	}
+
+	rules := pos.Rules()
+	exact := NewMinimax(0)
+	values := exact.Evaluate(pos)
+	best := -WinScore - 1
+	for _, v := range values {
+		if v > best {
+			best = v
+		}
+	}
+	var mistakes []Move
+	for m, v := range values {
+		if scoreOutcome(v) >= scoreOutcome(best) {
+			continue
+		}
+		child := pos.Clone()
+		if _, err := rules.Apply(child, m); err != nil {
+			continue
+		}
+		reply, _, ok := NewMinimax(2).Search(child)
+		if !ok {
+			continue
+		}
+		if _, err := rules.Apply(child, reply); err != nil {
+			continue
+		}
+		if _, after, ok := exact.Search(child); ok && scoreOutcome(after) >= scoreOutcome(best) {
+			mistakes = append(mistakes, m)
+		}
+	}
+	if len(mistakes) == 0 {
+		return e.Engine.BestMove(p, side)
+	}
+	sortMoves(mistakes)
+	m := mistakes[rand.Intn(len(mistakes))]
+	return m, scoreEvaluation(values[m], true), nil
+}
+
+// scoreEvaluation переводит оценку Minimax в Evaluation. Ничья считается форсированной,
+// только если поиск дошёл до конца партии
+func scoreEvaluation(score int, exact bool) *Evaluation {
+	eval := &Evaluation{Score: score}
+	switch {
+	case score > winThreshold:
+		eval.Outcome = "win"
+	case score < -winThreshold:
+		eval.Outcome = "loss"
+	case exact:
+		eval.Outcome = "draw"
+	}
+	return eval
+}
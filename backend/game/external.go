@@ -0,0 +1,368 @@
+package game
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultExternalMoveTime — время на ход, которое получает внешний движок
+	DefaultExternalMoveTime = time.Second
+	// DefaultExternalProcesses — сколько процессов одного движка может работать одновременно
+	DefaultExternalProcesses = 4
+	// externalGrace — запас сверх времени на ход на обмен строками с процессом
+	externalGrace = 500 * time.Millisecond
+	// externalHandshakeTimeout — сколько ждать ответа tttpok после запуска процесса
+	externalHandshakeTimeout = 5 * time.Second
+	// externalStderrLines — сколько последних строк stderr хранится для сообщений об ошибках
+	externalStderrLines = 20
+)
+
+var (
+	ErrEngineTimeout = errors.New("Engine timed out")
+	ErrEngineExited  = errors.New("Engine process exited")
+)
+
+// ExternalEngine — движок в отдельном процессе, с которым сервер общается построчно
+// через stdin/stdout:
+//
+//	> tttp
+//	< tttpok
+//	> position <variant> <size> <winLength> <misere 0|1> <turn> <activeBoard> <rows> moves <x,y> ...
+//	> go <ms>
+//	< info score <n>   (необязательно)
+//	< bestmove <x> <y>
+//	> quit
+//
+// rows — строки доски через "/", пустая клетка — ".". Перед каждой позицией сервер повторяет
+// tttp и пропускает всё, что движок выведет до tttpok. Каждый запрос получает свой процесс
+// из пула: партии не ждут друг друга, пока процессов не больше DefaultExternalProcesses.
+// Процессы запускаются по мере надобности, после падения или таймаута процесс завершается
+type ExternalEngine struct {
+	MoveTime time.Duration
+
+	name string
+	path string
+	args []string
+
+	slots  chan struct{} // ограничивает число одновременно работающих процессов
+	mu     sync.Mutex
+	idle   []*engineProcess
+	stderr *stderrLog
+}
+
+// engineProcess — один запущенный процесс движка. В каждый момент с ним работает одна партия
+type engineProcess struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.ReadCloser
+	stderr  io.ReadCloser
+	lines   chan string
+	quit    chan struct{}
+	readers sync.WaitGroup // горутины, читающие stdout и stderr
+}
+
+func NewExternalEngine(name, path string, args ...string) *ExternalEngine {
+	return &ExternalEngine{
+		MoveTime: DefaultExternalMoveTime,
+		name:     name,
+		path:     path,
+		args:     args,
+		slots:    make(chan struct{}, DefaultExternalProcesses),
+		stderr:   &stderrLog{},
+	}
+}
+
+// LoadExternalEngines регистрирует внешние движки из строки вида
+// "name=/path/to/bot arg1 arg2;other=/path/to/other"
+func LoadExternalEngines(spec string) error {
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("invalid external engine %q, expected name=command", entry)
+		}
+		name, command := strings.TrimSpace(parts[0]), strings.Fields(parts[1])
+		if len(command) == 0 {
+			return fmt.Errorf("empty command for external engine %s", name)
+		}
+		if HasEngine(name) {
+			return fmt.Errorf("engine %s is already registered", name)
+		}
+		engine := NewExternalEngine(name, command[0], command[1:]...)
+		RegisterEngine(name, func() Engine { return engine })
+		log.Printf("Registered external engine %s: %s", name, strings.Join(command, " "))
+	}
+	return nil
+}
+
+func (e *ExternalEngine) Name() string {
+	return e.name
+}
+
+// BestMove отправляет позицию свободному процессу и ждёт ответ. Если процесс упал,
+// запрос повторяется один раз на новом процессе; после таймаута процесс завершается
+func (e *ExternalEngine) BestMove(p *Position, side string) (Move, *Evaluation, error) {
+	e.slots <- struct{}{}
+	defer func() { <-e.slots }()
+
+	pos := positionFor(p, side)
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		proc := e.takeIdle()
+		if proc == nil {
+			if proc, err = e.start(); err != nil {
+				log.Printf("Failed to start engine %s: %v", e.name, err)
+				continue
+			}
+		}
+
+		var m Move
+		var eval *Evaluation
+		m, eval, err = proc.query(pos, e.MoveTime)
+		if err == nil {
+			if _, applyErr := pos.Rules().Apply(pos.Clone(), m); applyErr != nil {
+				// Процесс, который сыграл недопустимый ход, в пул не возвращается
+				proc.stop()
+				return Move{}, nil, fmt.Errorf("engine %s played illegal move [%d,%d]: %v", e.name, m.X, m.Y, applyErr)
+			}
+			e.putIdle(proc)
+			return m, eval, nil
+		}
+		log.Printf("Engine %s failed: %v, stderr: %s", e.name, err, e.stderr)
+		proc.stop()
+		if err == ErrEngineTimeout {
+			break
+		}
+	}
+	return Move{}, nil, err
+}
+
+func (e *ExternalEngine) takeIdle() *engineProcess {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.idle) == 0 {
+		return nil
+	}
+	proc := e.idle[len(e.idle)-1]
+	e.idle = e.idle[:len(e.idle)-1]
+	return proc
+}
+
+func (e *ExternalEngine) putIdle(proc *engineProcess) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.idle = append(e.idle, proc)
+}
+
+// start запускает новый процесс и проверяет рукопожатие tttp/tttpok
+func (e *ExternalEngine) start() (*engineProcess, error) {
+	cmd := exec.Command(e.path, e.args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	proc := &engineProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		lines:  make(chan string),
+		quit:   make(chan struct{}),
+	}
+	proc.readers.Add(2)
+	go func() {
+		defer proc.readers.Done()
+		readLines(stdout, proc.lines, proc.quit)
+	}()
+	go func() {
+		defer proc.readers.Done()
+		e.stderr.capture(e.name, stderr)
+	}()
+
+	if err := proc.handshake(externalHandshakeTimeout); err != nil {
+		proc.stop()
+		return nil, err
+	}
+	log.Printf("Started engine %s (pid %d)", e.name, cmd.Process.Pid)
+	return proc, nil
+}
+
+// handshake отправляет tttp и пропускает всё, что процесс выведет до tttpok
+func (p *engineProcess) handshake(timeout time.Duration) error {
+	if err := p.send("tttp"); err != nil {
+		return err
+	}
+	deadline := time.After(timeout)
+	for {
+		line, err := p.readLine(deadline)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "tttpok" {
+			return nil
+		}
+	}
+}
+
+// stop завершает процесс. Wait закрывает каналы вывода сам, поэтому до него читающие
+// горутины должны закончить: закрываем их каналы и ждём (см. exec.Cmd.StdoutPipe)
+func (p *engineProcess) stop() {
+	p.send("quit")
+	p.stdin.Close()
+	close(p.quit)
+	p.cmd.Process.Kill()
+	p.stdout.Close()
+	p.stderr.Close()
+	p.readers.Wait()
+	p.cmd.Wait()
+}
+
+func (p *engineProcess) query(pos *Position, moveTime time.Duration) (Move, *Evaluation, error) {
+	// Процесс из пула мог вывести лишние строки после прошлого ответа, например второй bestmove.
+	// Повторное рукопожатие отбрасывает их, чтобы они не стали ответом на эту позицию
+	if err := p.handshake(externalGrace); err != nil {
+		return Move{}, nil, err
+	}
+	if err := p.send(positionCommand(pos)); err != nil {
+		return Move{}, nil, err
+	}
+	if err := p.send(fmt.Sprintf("go %d", moveTime.Milliseconds())); err != nil {
+		return Move{}, nil, err
+	}
+
+	deadline := time.After(moveTime + externalGrace)
+	var eval *Evaluation
+	for {
+		line, err := p.readLine(deadline)
+		if err != nil {
+			return Move{}, nil, err
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 3 && fields[0] == "info" && fields[1] == "score":
+			if score, err := strconv.Atoi(fields[2]); err == nil {
+				eval = &Evaluation{Score: score}
+			}
+		case len(fields) == 3 && fields[0] == "bestmove":
+			x, errX := strconv.Atoi(fields[1])
+			y, errY := strconv.Atoi(fields[2])
+			if errX != nil || errY != nil {
+				return Move{}, nil, fmt.Errorf("invalid bestmove %q", line)
+			}
+			return Move{X: x, Y: y}, eval, nil
+		}
+	}
+}
+
+func (p *engineProcess) send(line string) error {
+	_, err := io.WriteString(p.stdin, line+"\n")
+	return err
+}
+
+func (p *engineProcess) readLine(deadline <-chan time.Time) (string, error) {
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			return "", ErrEngineExited
+		}
+		return line, nil
+	case <-deadline:
+		return "", ErrEngineTimeout
+	}
+}
+
+func readLines(r io.Reader, lines chan<- string, quit <-chan struct{}) {
+	defer close(lines)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		select {
+		case lines <- scanner.Text():
+		case <-quit:
+			return
+		}
+	}
+}
+
+// positionCommand записывает позицию строкой протокола
+func positionCommand(p *Position) string {
+	variant := p.Variant
+	if variant == "" {
+		variant = DefaultVariant
+	}
+	misere := 0
+	if p.Misere {
+		misere = 1
+	}
+
+	rows := make([]string, len(p.Board))
+	for i, row := range p.Board {
+		var sb strings.Builder
+		for _, cell := range row {
+			if cell == "" {
+				cell = "."
+			}
+			sb.WriteString(cell)
+		}
+		rows[i] = sb.String()
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "position %s %d %d %d %s %d %s moves", variant, p.Size, p.WinLength, misere, p.Turn, p.ActiveBoard, strings.Join(rows, "/"))
+	for _, m := range p.History {
+		fmt.Fprintf(&sb, " %d,%d", m.X, m.Y)
+	}
+	return sb.String()
+}
+
+// stderrLog пишет stderr движка в лог сервера и хранит последние строки
+type stderrLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *stderrLog) capture(name string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		log.Printf("[engine %s] %s", name, line)
+		l.mu.Lock()
+		l.lines = append(l.lines, line)
+		if len(l.lines) > externalStderrLines {
+			l.lines = l.lines[len(l.lines)-externalStderrLines:]
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (l *stderrLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.lines) == 0 {
+		return "(empty)"
+	}
+	return strings.Join(l.lines, " | ")
+}
@@ -1,48 +1,184 @@
 package game
 
-type Board [3][3]string
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	DefaultSize      = 3
+	DefaultWinLength = 3
+	MinSize          = 3
+	MaxSize          = 19
+)
+
+type Board [][]string
+
+// Options — параметры, с которыми создаётся партия
+type Options struct {
+	Variant   string `json:"variant"`
+	Size      int    `json:"size"`
+	WinLength int    `json:"winLength"`
+	Misere    bool   `json:"misere"` // собравший линию проигрывает
+}
 
 type Game struct {
 	ID        int
 	Player1ID int
 	Player2ID int
-	Board     Board
-	Status    string // "waiting", "active", "finished"
-	Turn      string // "X" или "O"
-	WinnerID  int
+	Position
+	Status     string // "waiting", "active", "finished"
+	WinnerID   int
+	Difficulty string // уровень ИИ в оффлайн-партии, пустой в онлайн-партиях
+	Engine     string // движок ИИ, выбранный явно вместо уровня сложности
+	AISymbol   string // чем играет ИИ в оффлайн-партии, пустой в онлайн-партиях
+	// BotNickname и BotID — имя и ID, под которыми ИИ показан игроку, если партия началась
+	// из очереди. Player2ID при этом остаётся 0, как в любой оффлайн-партии
+	BotNickname string
+	BotID       int
+
+	Player1Hints int // сколько подсказок взял каждый игрок
+	Player2Hints int
+
+	aiThinking  bool      // ИИ ищет ход без блокировки менеджера
+	hintPending bool      // подсказка ищется без блокировки менеджера
+	lastHintAt  time.Time // когда в партии последний раз начали искать подсказку
+}
+
+// boardRecord — то, что хранится в колонке games.board
+type boardRecord struct {
+	Variant     string `json:"variant"`
+	Size        int    `json:"size"`
+	WinLength   int    `json:"winLength"`
+	Misere      bool   `json:"misere"`
+	Cells       Board  `json:"cells"`
+	ActiveBoard int    `json:"activeBoard"`
+}
+
+func DefaultOptions() Options {
+	return Options{Variant: DefaultVariant, Size: DefaultSize, WinLength: DefaultWinLength}
+}
+
+// Validate проверяет размер доски и длину выигрышной линии
+func (o Options) Validate() error {
+	if o.Size < MinSize || o.Size > MaxSize {
+		return errors.New("board size must be between 3 and 19")
+	}
+	if o.WinLength < 3 || o.WinLength > o.Size {
+		return errors.New("win length must be between 3 and the board size")
+	}
+	return nil
+}
+
+// NewGame создаёт активную партию в начальной позиции выбранного варианта
+func NewGame(id, player1ID, player2ID int, opts Options) *Game {
+	return &Game{
+		ID:        id,
+		Player1ID: player1ID,
+		Player2ID: player2ID,
+		Position:  RulesFor(opts).NewPosition(opts),
+		Status:    "active",
+	}
 }
 
-func (b *Board) MakeMove(x, y int, player string) bool {
-	if x < 0 || x > 2 || y < 0 || y > 2 || b[x][y] != "" {
+// PlayerSymbol возвращает символ игрока в партии или пустую строку, если он в ней не участвует.
+// В оффлайн-партии человек играет символом, противоположным AISymbol
+func (g *Game) PlayerSymbol(playerID int) string {
+	switch {
+	case g.Player2ID == 0 && playerID == g.Player1ID:
+		return nextTurn(g.AISymbol)
+	case playerID == g.Player1ID:
+		return "X"
+	case playerID == g.Player2ID:
+		return "O"
+	}
+	return ""
+}
+
+// PlayerBySymbol возвращает ID игрока, который играет символом, или 0 для ИИ
+func (g *Game) PlayerBySymbol(symbol string) int {
+	if g.PlayerSymbol(g.Player1ID) == symbol {
+		return g.Player1ID
+	}
+	return g.Player2ID
+}
+
+// BoardJSON возвращает доску вместе с её размерами для сохранения в games.board
+func (g *Game) BoardJSON() []byte {
+	data, _ := json.Marshal(boardRecord{
+		Variant:     g.Variant,
+		Size:        g.Size,
+		WinLength:   g.WinLength,
+		Misere:      g.Misere,
+		Cells:       g.Board,
+		ActiveBoard: g.ActiveBoard,
+	})
+	return data
+}
+
+func NewBoard(size int) Board {
+	b := make(Board, size)
+	for i := range b {
+		b[i] = make([]string, size)
+	}
+	return b
+}
+
+func (b Board) Size() int {
+	return len(b)
+}
+
+func (b Board) InBounds(x, y int) bool {
+	return x >= 0 && x < len(b) && y >= 0 && y < len(b)
+}
+
+func (b Board) MakeMove(x, y int, player string) bool {
+	if !b.InBounds(x, y) || b[x][y] != "" {
 		return false
 	}
 	b[x][y] = player
 	return true
 }
 
-func (b *Board) CheckWinner() string {
-	// rows check
-	for i := 0; i < 3; i++ {
-		if b[i][0] == b[i][1] && b[i][1] == b[i][2] && b[i][0] != "" {
-			return b[i][0]
+// CheckWinner ищет winLength одинаковых символов подряд по строке, столбцу или диагонали
+func (b Board) CheckWinner(winLength int) string {
+	directions := [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	for i := range b {
+		for j := range b[i] {
+			if b[i][j] == "" {
+				continue
+			}
+			for _, d := range directions {
+				count := 1
+				for count < winLength && b.InBounds(i+d[0]*count, j+d[1]*count) && b[i+d[0]*count][j+d[1]*count] == b[i][j] {
+					count++
+				}
+				if count == winLength {
+					return b[i][j]
+				}
+			}
 		}
 	}
 
-	// columns check
-	for i := 0; i < 3; i++ {
-		if b[0][i] == b[1][i] && b[1][i] == b[2][i] && b[0][i] != "" {
-			return b[0][i]
+	return ""
+}
+
+func (b Board) IsFull() bool {
+	for i := range b {
+		for j := range b[i] {
+			if b[i][j] == "" {
+				return false
+			}
 		}
 	}
+	return true
+}
 
-	// diagonals check
-	if b[0][0] == b[1][1] && b[1][1] == b[2][2] && b[0][0] != "" {
-		return b[0][0]
+func (b Board) Clone() Board {
+	c := make(Board, len(b))
+	for i := range b {
+		c[i] = append([]string(nil), b[i]...)
 	}
-	if b[0][2] == b[1][1] && b[1][1] == b[2][0] && b[0][2] != "" {
-		return b[0][2]
-	}
-
-	return ""
-
-}
\ No newline at end of file
+	return c
+}
@@ -0,0 +1,85 @@
+package game
+
+import "errors"
+
+const (
+	DefaultGravitySize      = 7
+	DefaultGravityWinLength = 4
+)
+
+var ErrColumnFull = errors.New("Column is full")
+
+// gravityRules — «четыре в ряд»: игрок выбирает только столбец (Move.Y),
+// символ падает в самую нижнюю свободную клетку. Move.X при ходе игнорируется
+type gravityRules struct{}
+
+func init() {
+	RegisterRuleset(gravityRules{})
+}
+
+func (gravityRules) Name() string {
+	return "gravity"
+}
+
+func (gravityRules) Normalize(opts Options) (Options, error) {
+	if opts.Size == 0 {
+		opts.Size = DefaultGravitySize
+	}
+	if opts.WinLength == 0 {
+		opts.WinLength = DefaultGravityWinLength
+		if opts.WinLength > opts.Size {
+			opts.WinLength = opts.Size
+		}
+	}
+	return opts, opts.Validate()
+}
+
+func (gravityRules) NewPosition(opts Options) Position {
+	return Position{Options: opts, Board: NewBoard(opts.Size), Turn: "X", ActiveBoard: AnyBoard}
+}
+
+func (r gravityRules) LegalMoves(p *Position) []Move {
+	if r.Result(p).Finished {
+		return nil
+	}
+	moves := make([]Move, 0)
+	for col := range p.Board {
+		if row := landingRow(p.Board, col); row >= 0 {
+			moves = append(moves, Move{X: row, Y: col})
+		}
+	}
+	return moves
+}
+
+func (r gravityRules) Apply(p *Position, m Move) (Move, error) {
+	if r.Result(p).Finished {
+		return m, ErrGameFinished
+	}
+	if m.Y < 0 || m.Y >= p.Board.Size() {
+		return m, ErrInvalidCoordinates
+	}
+	row := landingRow(p.Board, m.Y)
+	if row < 0 {
+		return m, ErrColumnFull
+	}
+	m.X = row
+	p.place(m)
+	return m, nil
+}
+
+func (gravityRules) Result(p *Position) Result {
+	if winner := p.Board.CheckWinner(p.WinLength); winner != "" {
+		return Result{Finished: true, Winner: winner}
+	}
+	return Result{Finished: p.Board.IsFull()}
+}
+
+// landingRow возвращает строку, на которую упадёт символ в столбце col, или -1, если столбец заполнен
+func landingRow(board Board, col int) int {
+	for row := board.Size() - 1; row >= 0; row-- {
+		if board[row][col] == "" {
+			return row
+		}
+	}
+	return -1
+}
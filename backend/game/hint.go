@@ -0,0 +1,71 @@
+package game
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// MaxHints — сколько подсказок может взять каждый игрок за партию
+	MaxHints = 3
+	// HintCooldown — не чаще скольких секунд в партии можно просить подсказку
+	HintCooldown = 2 * time.Second
+	// HintTimeBudget — сколько времени ищется подсказка
+	HintTimeBudget = time.Second
+)
+
+var (
+	ErrHintTimeout = errors.New("Hint search timed out")
+	// errStaleHint — пока искалась подсказка, в партии сделали ход
+	errStaleHint = errors.New("game changed while the hint was calculated")
+)
+
+// HintsUsed возвращает, сколько подсказок взял игрок в этой партии
+func (g *Game) HintsUsed(playerID int) int {
+	if playerID == g.Player1ID {
+		return g.Player1Hints
+	}
+	if playerID == g.Player2ID {
+		return g.Player2Hints
+	}
+	return 0
+}
+
+func (g *Game) addHint(playerID int) {
+	if playerID == g.Player1ID {
+		g.Player1Hints++
+	} else if playerID == g.Player2ID {
+		g.Player2Hints++
+	}
+}
+
+// HintedWin сообщает, что победитель партии пользовался подсказками. Такие победы
+// не идут в статистику
+func (g *Game) HintedWin() bool {
+	return g.WinnerID != 0 && g.HintsUsed(g.WinnerID) > 0
+}
+
+// Hint ищет лучший ход для стороны, чей сейчас ход, поиском Minimax без дебютной книги,
+// чтобы вместе с ходом вернуть его оценку. Глубина растёт до DefaultSearchDepth, пока
+// не кончится HintTimeBudget, поэтому и на больших досках форсированный результат виден в Outcome
+func Hint(p *Position) (Move, *Evaluation, error) {
+	search := NewMinimax(0)
+	search.Deadline = time.Now().Add(HintTimeBudget)
+	var eval *Evaluation
+	var best Move
+	for _, depth := range deepening(DefaultSearchDepth(p)) {
+		search.MaxDepth = depth
+		m, score, ok := search.Search(p)
+		if !ok {
+			break
+		}
+		best, eval = m, scoreEvaluation(score, depth == 0)
+	}
+	if eval == nil {
+		if search.TimedOut() {
+			return Move{}, nil, ErrHintTimeout
+		}
+		return Move{}, nil, ErrNoMoves
+	}
+	return best, eval, nil
+}
@@ -0,0 +1,92 @@
+package game
+
+import (
+	"math/rand"
+	"sort"
+)
+
+const (
+	// Параметры HumanLike по умолчанию, на них играет лёгкий уровень
+	DefaultHumanDepth         = 2
+	DefaultHumanMistakeChance = 0.35
+	DefaultHumanMaxError      = 150
+	DefaultHumanBlunderChance = 0.1
+)
+
+// HumanLike — ИИ, который ошибается как человек. Он оценивает все ходы поиском глубины Depth
+// и обычно выбирает лучший, но с вероятностью MistakeChance берёт ход хуже лучшего не больше
+// чем на MaxError, а с вероятностью BlunderChance — любой ход. Поиск глубины 2 видит выигрыш
+// и угрозу соперника в один ход, поэтому очевидные тройки пропускаются только при грубой ошибке
+type HumanLike struct {
+	Depth         int
+	MistakeChance float64
+	MaxError      int
+	BlunderChance float64
+}
+
+func NewHumanLike() *HumanLike {
+	return &HumanLike{
+		Depth:         DefaultHumanDepth,
+		MistakeChance: DefaultHumanMistakeChance,
+		MaxError:      DefaultHumanMaxError,
+		BlunderChance: DefaultHumanBlunderChance,
+	}
+}
+
+func init() {
+	RegisterEngine("human", func() Engine { return NewHumanLike() })
+}
+
+func (*HumanLike) Name() string {
+	return "human"
+}
+
+func (h *HumanLike) BestMove(p *Position, side string) (Move, *Evaluation, error) {
+	values := NewMinimax(h.Depth).Evaluate(positionFor(p, side))
+	if len(values) == 0 {
+		return Move{}, nil, ErrNoMoves
+	}
+	best := -WinScore - 1
+	for _, v := range values {
+		if v > best {
+			best = v
+		}
+	}
+
+	var candidates []Move
+	r := rand.Float64()
+	switch {
+	case r < h.BlunderChance:
+		for m := range values {
+			candidates = append(candidates, m)
+		}
+	case r < h.BlunderChance+h.MistakeChance:
+		for m, v := range values {
+			if v < best && best-v <= h.MaxError {
+				candidates = append(candidates, m)
+			}
+		}
+	}
+	// Ошибаться некуда — все ходы либо лучшие, либо слишком плохие
+	if len(candidates) == 0 {
+		for m, v := range values {
+			if v == best {
+				candidates = append(candidates, m)
+			}
+		}
+	}
+
+	// Ходы из map идут в случайном порядке, сортируем их, чтобы выбор зависел только от rand
+	sortMoves(candidates)
+	m := candidates[rand.Intn(len(candidates))]
+	return m, scoreEvaluation(values[m], false), nil
+}
+
+func sortMoves(moves []Move) {
+	sort.Slice(moves, func(i, j int) bool {
+		if moves[i].X != moves[j].X {
+			return moves[i].X < moves[j].X
+		}
+		return moves[i].Y < moves[j].Y
+	})
+}
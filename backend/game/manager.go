@@ -1,7 +1,8 @@
 package game
 
 import (
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"log"
 	"sync"
 	"time"
@@ -9,609 +10,1027 @@ import (
 	"github.com/gorilla/websocket"
 
 	"tictactoe/db"
+	"tictactoe/utils"
 )
 
 type Stats struct {
-    Online     int `json:"online"`
-    Games      int `json:"games"`
-    TotalGames int `json:"totalGames"`
+	Online     int `json:"online"`
+	Games      int `json:"games"`
+	TotalGames int `json:"totalGames"`
 }
 
 type GameManager struct {
-    games           map[int]*Game
-    waiting         []int
-    mu              sync.Mutex
-    clients         map[int]*Client
-    rematchRequests map[int]map[int]bool
+	games           map[int]*Game
+	waiting         []waitingPlayer
+	mu              sync.Mutex
+	clients         map[int]*Client
+	rematchRequests map[int]map[int]bool
+	rematches       map[int]*rematch
+
+	// MaxQueueWait — сколько игрок может ждать соперника, прежде чем его уберут из очереди
+	MaxQueueWait time.Duration
+	// AIFallbackAfter — через сколько ожидания предложить игру с ИИ, 0 — не предлагать
+	AIFallbackAfter time.Duration
+	// RoomTTL — сколько приватная комната ждёт второго игрока
+	RoomTTL         time.Duration
+	rooms           map[string]*Room
+	recentWaits     []time.Duration
+	lastQueueStatus time.Time
+}
+
+// rematchTTL — сколько согласованный реванш ждёт start_rematch от игроков
+const rematchTTL = time.Minute
+
+// rematch — реванш, на который согласились оба игрока завершённой партии
+type rematch struct {
+	Player1ID int
+	Player2ID int
+	Options   Options
+	GameID    int // партия реванша, 0 — ещё не создана
+	ExpiresAt time.Time
+}
+
+// waitingPlayer — игрок в очереди вместе с параметрами партии, которую он ищет
+type waitingPlayer struct {
+	PlayerID int
+	Options  Options
+	Rating   float64
+	Since    time.Time
+	AutoAI   bool // согласен сразу играть с ИИ, если соперник долго не находится
+	Offered  bool // игроку уже предложили сыграть с ИИ
 }
 
 type Client struct {
-    Conn     *websocket.Conn
-    PlayerID int
+	Conn     *websocket.Conn
+	PlayerID int
 }
 
 func NewGameManager() *GameManager {
-    return &GameManager{
-        games:           make(map[int]*Game),
-        waiting:         make([]int, 0),
-        clients:         make(map[int]*Client),
-        rematchRequests: make(map[int]map[int]bool),
-    }
+	return &GameManager{
+		games:           make(map[int]*Game),
+		waiting:         make([]waitingPlayer, 0),
+		clients:         make(map[int]*Client),
+		rematchRequests: make(map[int]map[int]bool),
+		rematches:       make(map[int]*rematch),
+		MaxQueueWait:    DefaultMaxQueueWait,
+		AIFallbackAfter: DefaultAIFallbackAfter,
+		RoomTTL:         DefaultRoomTTL,
+		rooms:           make(map[string]*Room),
+	}
 }
 
 func (gm *GameManager) RegisterClient(playerID int, conn *websocket.Conn) {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-    if oldClient, ok := gm.clients[playerID]; ok {
-        oldClient.Conn.Close()
-        log.Printf("Closed old connection for player %d", playerID)
-    }
-    gm.clients[playerID] = &Client{Conn: conn, PlayerID: playerID}
-    log.Printf("Registered client for player %d, total clients: %d", playerID, len(gm.clients))
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	if oldClient, ok := gm.clients[playerID]; ok {
+		oldClient.Conn.Close()
+		log.Printf("Closed old connection for player %d", playerID)
+	}
+	gm.clients[playerID] = &Client{Conn: conn, PlayerID: playerID}
+	log.Printf("Registered client for player %d, total clients: %d", playerID, len(gm.clients))
 }
 
 func (gm *GameManager) GetStats() Stats {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-    totalGames := 0
-    row := db.DB.QueryRow("SELECT COUNT(*) FROM games")
-    _ = row.Scan(&totalGames)
-    return Stats{
-        Online:     len(gm.clients),
-        Games:      len(gm.games),
-        TotalGames: totalGames,
-    }
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	totalGames := 0
+	row := db.DB.QueryRow("SELECT COUNT(*) FROM games")
+	_ = row.Scan(&totalGames)
+	return Stats{
+		Online:     len(gm.clients),
+		Games:      len(gm.games),
+		TotalGames: totalGames,
+	}
 }
 
 func (gm *GameManager) GetGame(gameID int) (*Game, bool) {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-    game, ok := gm.games[gameID]
-    return game, ok
-}
-
-func (gm *GameManager) CreateOfflineGame(playerID int) int {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-
-    gameID := len(gm.games) + 1
-    game := &Game{
-        ID:        gameID,
-        Player1ID: playerID,
-        Player2ID: 0,
-        Status:    "active",
-        Turn:      "X",
-        Board:     [3][3]string{},
-    }
-    gm.games[gameID] = game
-
-    boardJSON, _ := json.Marshal(game.Board)
-    _, err := db.DB.Exec(
-        "INSERT INTO games (id, player1_id, player2_id, status, turn, board) VALUES ($1, $2, $3, $4, $5, $6)",
-        gameID, playerID, nil, game.Status, game.Turn, boardJSON,
-    )
-    if err != nil {
-        log.Printf("Failed to save offline game %d: %v", gameID, err)
-    }
-
-    log.Printf("Created offline game %d for player %d", gameID, playerID)
-    return gameID
-}
-
-func (gm *GameManager) FindOpponent(playerID int) int {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-
-    log.Printf("Finding opponent for player %d, waiting list: %v", playerID, gm.waiting)
-    if len(gm.waiting) > 0 {
-        opponentID := gm.waiting[0]
-        gm.waiting = gm.waiting[1:]
-
-        gameID := len(gm.games) + 1
-        game := &Game{
-            ID:        gameID,
-            Player1ID: playerID,
-            Player2ID: opponentID,
-            Status:    "active",
-            Turn:      "X",
-            Board:     [3][3]string{},
-        }
-        gm.games[gameID] = game
-
-        boardJSON, _ := json.Marshal(game.Board)
-        _, err := db.DB.Exec(
-            "INSERT INTO games (id, player1_id, player2_id, status, turn, board) VALUES ($1, $2, $3, $4, $5, $6)",
-            gameID, playerID, opponentID, game.Status, game.Turn, boardJSON,
-        )
-        if err != nil {
-            log.Printf("Failed to save game %d: %v", gameID, err)
-        }
-
-        go func() {
-            time.Sleep(1000 * time.Millisecond)
-            gm.NotifyPlayers(game)
-        }()
-        return opponentID
-    }
-
-    gm.waiting = append(gm.waiting, playerID)
-    log.Printf("Player %d added to waiting list", playerID)
-    return 0
-}
-
-func (gm *GameManager) CreateRematch(player1ID, player2ID int) int {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-
-    gameID := len(gm.games) + 1
-    game := &Game{
-        ID:        gameID,
-        Player1ID: player1ID,
-        Player2ID: player2ID,
-        Status:    "active",
-        Turn:      "X",
-        Board:     [3][3]string{},
-    }
-    gm.games[gameID] = game
-
-    boardJSON, _ := json.Marshal(game.Board)
-    _, err := db.DB.Exec(
-        "INSERT INTO games (id, player1_id, player2_id, status, turn, board) VALUES ($1, $2, $3, $4, $5, $6)",
-        gameID, player1ID, player2ID, game.Status, game.Turn, boardJSON,
-    )
-    if err != nil {
-        log.Printf("Failed to save rematch game %d: %v", gameID, err)
-    }
-
-    log.Printf("Created rematch game %d for players %d and %d", gameID, player1ID, player2ID)
-    return gameID
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	game, ok := gm.games[gameID]
+	return game, ok
+}
+
+// CreateOfflineGame создаёт партию против ИИ. Если engine не пустой, за ИИ играет этот движок
+// из реестра, иначе — движок уровня difficulty
+func (gm *GameManager) CreateOfflineGame(playerID int, opts Options, difficulty, engine string) (int, error) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	game, err := gm.createOfflineGame(playerID, opts, difficulty, engine)
+	if err != nil {
+		return 0, err
+	}
+	return game.ID, nil
+}
+
+// createOfflineGame создаёт партию против ИИ. Вызывается под gm.mu
+func (gm *GameManager) createOfflineGame(playerID int, opts Options, difficulty, engine string) (*Game, error) {
+	game := NewGame(0, playerID, 0, opts)
+	game.Difficulty = difficulty
+	game.Engine = engine
+	game.AISymbol = "O"
+	if _, err := gm.createGame(game); err != nil {
+		return nil, err
+	}
+	log.Printf("Created offline %s game %d (%dx%d, %d in a row, misere: %v, engine: %s) for player %d", opts.Variant, game.ID, opts.Size, opts.Size, opts.WinLength, opts.Misere, game.EngineName(), playerID)
+	return game, nil
+}
+
+// createGame сохраняет новую партию в БД и регистрирует её под выданным БД номером.
+// Если сохранить не удалось, партия не создаётся. Вызывается под gm.mu
+func (gm *GameManager) createGame(game *Game) (*Game, error) {
+	var difficulty, engine interface{}
+	if game.Difficulty != "" {
+		difficulty = game.Difficulty
+	}
+	if game.Engine != "" {
+		engine = game.Engine
+	}
+	err := db.DB.QueryRow(
+		"INSERT INTO games (player1_id, player2_id, status, turn, board, difficulty, engine) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		game.Player1ID, nullableID(game.Player2ID), game.Status, game.Turn, game.BoardJSON(), difficulty, engine,
+	).Scan(&game.ID)
+	if err != nil {
+		log.Printf("Failed to save game for players %d and %d: %v", game.Player1ID, game.Player2ID, err)
+		return nil, ErrCreateGame
+	}
+	gm.games[game.ID] = game
+	return game, nil
+}
+
+// FindOpponent ставит игрока в очередь и сразу пробует подобрать ему соперника с близким
+// рейтингом. Если подходящего нет, игрока позже сведёт фоновый RunMatchmaker.
+// С autoAI игрок согласен сразу играть с ИИ, если ждать придётся дольше AIFallbackAfter.
+// Возвращает ID соперника или 0
+func (gm *GameManager) FindOpponent(playerID int, opts Options, autoAI bool) int {
+	rating := gm.GetPlayerRating(playerID)
+
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	gm.removeWaiting(playerID)
+	gm.waiting = append(gm.waiting, waitingPlayer{PlayerID: playerID, Options: opts, Rating: rating.Rating, Since: time.Now(), AutoAI: autoAI})
+	log.Printf("Player %d (rating %.0f) added to waiting list, waiting: %d", playerID, rating.Rating, len(gm.waiting))
+
+	for _, game := range gm.matchWaiting(time.Now()) {
+		if game.Player1ID == playerID {
+			return game.Player2ID
+		}
+		if game.Player2ID == playerID {
+			return game.Player1ID
+		}
+	}
+	return 0
+}
+
+// ErrCreateGame — партию не удалось сохранить в БД
+var ErrCreateGame = errors.New("Failed to create game")
+
+// ErrNoRematch — start_rematch без реванша, на который согласились оба игрока партии
+var ErrNoRematch = errors.New("Rematch not confirmed by both players")
+
+// CreateRematch создаёт партию реванша с параметрами партии gameID, если на реванш согласились
+// оба её игрока. start_rematch присылают оба игрока: второй получает уже созданную партию
+// с created == false, и реванш забывается
+func (gm *GameManager) CreateRematch(gameID, player1ID, player2ID int) (newGameID int, created bool, err error) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	r, ok := gm.rematches[gameID]
+	if !ok || !r.hasPlayers(player1ID, player2ID) {
+		return 0, false, ErrNoRematch
+	}
+	if r.GameID != 0 {
+		delete(gm.rematches, gameID)
+		return r.GameID, false, nil
+	}
+
+	game, err := gm.createGame(NewGame(0, player1ID, player2ID, r.Options))
+	if err != nil {
+		return 0, false, err
+	}
+	r.GameID = game.ID
+	log.Printf("Created rematch game %d for players %d and %d", game.ID, player1ID, player2ID)
+	return game.ID, true, nil
+}
+
+func (r *rematch) hasPlayers(player1ID, player2ID int) bool {
+	return (r.Player1ID == player1ID && r.Player2ID == player2ID) || (r.Player1ID == player2ID && r.Player2ID == player1ID)
+}
+
+// expireRematches забывает реванши, которые так и не начали или начал только один игрок.
+// Вызывается под gm.mu
+func (gm *GameManager) expireRematches(now time.Time) {
+	for gameID, r := range gm.rematches {
+		if now.After(r.ExpiresAt) {
+			delete(gm.rematches, gameID)
+		}
+	}
+}
+
+// ErrUnknownToken — токен не принадлежит ни одному игроку
+var ErrUnknownToken = errors.New("Unknown player token")
+
+// PlayerByToken находит игрока по токену, который выдают /quick-game, /offline-game и /rooms
+func PlayerByToken(token string) (playerID int, nickname string, err error) {
+	err = db.DB.QueryRow("SELECT id, nickname FROM users WHERE token_hash = $1", utils.HashToken(token)).Scan(&playerID, &nickname)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrUnknownToken
+	}
+	return playerID, nickname, err
 }
 
 func (gm *GameManager) GetPlayerNickname(playerID int) string {
-    var nickname string
-    err := db.DB.QueryRow("SELECT nickname FROM users WHERE id = $1", playerID).Scan(&nickname)
-    if err != nil {
-        log.Printf("Failed to get nickname for player %d: %v", playerID, err)
-        return "Unknown"
-    }
-    return nickname
+	var nickname string
+	err := db.DB.QueryRow("SELECT nickname FROM users WHERE id = $1", playerID).Scan(&nickname)
+	if err != nil {
+		log.Printf("Failed to get nickname for player %d: %v", playerID, err)
+		return "Unknown"
+	}
+	return nickname
+}
+
+// GetPlayerRating возвращает рейтинг игрока, при ошибке БД — начальный
+func (gm *GameManager) GetPlayerRating(playerID int) Rating {
+	rating, err := LoadRating(playerID)
+	if err != nil {
+		log.Printf("Failed to get rating for player %d: %v", playerID, err)
+		return DefaultPlayerRating()
+	}
+	return rating
 }
 
 func (gm *GameManager) NotifyPlayers(game *Game) {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-
-    log.Printf("Notifying players %d and %d for game %d", game.Player1ID, game.Player2ID, game.ID)
-    if client1, ok := gm.clients[game.Player1ID]; ok {
-        state1 := map[string]interface{}{
-            "type":      "game_start",
-            "gameID":    game.ID,
-            "board":     game.Board,
-            "turn":      game.Turn,
-            "player1":   game.Player1ID,
-            "player2":   game.Player2ID,
-            "role":      "X",
-            "nickname":  gm.GetPlayerNickname(game.Player1ID),
-            "opponentNickname": gm.GetPlayerNickname(game.Player2ID),
-        }
-        err := client1.Conn.WriteJSON(state1)
-        if err != nil {
-            log.Printf("Failed to notify player %d: %v", game.Player1ID, err)
-        } else {
-            log.Printf("Notified player %d with game state", game.Player1ID)
-        }
-    } else {
-        log.Printf("Player %d not found in clients", game.Player1ID)
-    }
-
-    if game.Player2ID != 0 {
-        if client2, ok := gm.clients[game.Player2ID]; ok {
-            state2 := map[string]interface{}{
-                "type":      "game_start",
-                "gameID":    game.ID,
-                "board":     game.Board,
-                "turn":      game.Turn,
-                "player1":   game.Player1ID,
-                "player2":   game.Player2ID,
-                "role":      "O",
-                "nickname":  gm.GetPlayerNickname(game.Player2ID),
-                "opponentNickname": gm.GetPlayerNickname(game.Player1ID),
-            }
-            err := client2.Conn.WriteJSON(state2)
-            if err != nil {
-                log.Printf("Failed to notify player %d: %v", game.Player2ID, err)
-            } else {
-                log.Printf("Notified player %d with game state", game.Player2ID)
-            }
-        } else {
-            log.Printf("Player %d not found in clients", game.Player2ID)
-        }
-    }
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	log.Printf("Notifying players %d and %d for game %d", game.Player1ID, game.Player2ID, game.ID)
+	if client1, ok := gm.clients[game.Player1ID]; ok {
+		state1 := map[string]interface{}{
+			"type":             "game_start",
+			"gameID":           game.ID,
+			"board":            game.Board,
+			"turn":             game.Turn,
+			"variant":          game.Variant,
+			"size":             game.Size,
+			"winLength":        game.WinLength,
+			"misere":           game.Misere,
+			"activeBoard":      game.ActiveBoard,
+			"player1":          game.Player1ID,
+			"player2":          game.Player2ID,
+			"role":             "X",
+			"nickname":         gm.GetPlayerNickname(game.Player1ID),
+			"opponentNickname": gm.GetPlayerNickname(game.Player2ID),
+		}
+		if game.BotNickname != "" {
+			// Партия с ИИ из очереди выглядит для игрока как обычная онлайн-партия
+			state1["player2"] = game.BotID
+			state1["opponentNickname"] = game.BotNickname
+			state1["rating"] = gm.GetPlayerRating(game.Player1ID)
+			state1["opponentRating"] = BotRating(game.Difficulty)
+		}
+		if game.Player2ID != 0 {
+			state1["rating"] = gm.GetPlayerRating(game.Player1ID)
+			state1["opponentRating"] = gm.GetPlayerRating(game.Player2ID)
+		}
+		err := client1.Conn.WriteJSON(state1)
+		if err != nil {
+			log.Printf("Failed to notify player %d: %v", game.Player1ID, err)
+		} else {
+			log.Printf("Notified player %d with game state", game.Player1ID)
+		}
+	} else {
+		log.Printf("Player %d not found in clients", game.Player1ID)
+	}
+
+	if game.Player2ID != 0 {
+		if client2, ok := gm.clients[game.Player2ID]; ok {
+			state2 := map[string]interface{}{
+				"type":             "game_start",
+				"gameID":           game.ID,
+				"board":            game.Board,
+				"turn":             game.Turn,
+				"variant":          game.Variant,
+				"size":             game.Size,
+				"winLength":        game.WinLength,
+				"misere":           game.Misere,
+				"activeBoard":      game.ActiveBoard,
+				"player1":          game.Player1ID,
+				"player2":          game.Player2ID,
+				"role":             "O",
+				"nickname":         gm.GetPlayerNickname(game.Player2ID),
+				"opponentNickname": gm.GetPlayerNickname(game.Player1ID),
+				"rating":           gm.GetPlayerRating(game.Player2ID),
+				"opponentRating":   gm.GetPlayerRating(game.Player1ID),
+			}
+			err := client2.Conn.WriteJSON(state2)
+			if err != nil {
+				log.Printf("Failed to notify player %d: %v", game.Player2ID, err)
+			} else {
+				log.Printf("Notified player %d with game state", game.Player2ID)
+			}
+		} else {
+			log.Printf("Player %d not found in clients", game.Player2ID)
+		}
+	}
 }
 
 func (gm *GameManager) HandleMove(gameID, playerID, x, y int) {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-
-    game, ok := gm.games[gameID]
-    if !ok {
-        log.Printf("Game %d not found for player %d", gameID, playerID)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "warning",
-                "message": "Game not found",
-            })
-        }
-        return
-    }
-
-    if game.Player1ID != playerID && game.Player2ID != playerID {
-        log.Printf("Player %d is not part of game %d", playerID, gameID)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "warning",
-                "message": "You are not part of this game",
-            })
-        }
-        return
-    }
-
-    playerSymbol := "X"
-    if game.Player2ID == playerID {
-        playerSymbol = "O"
-    }
-
-    if game.Turn != playerSymbol {
-        log.Printf("Not player %d's turn (%s), current turn: %s", playerID, playerSymbol, game.Turn)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "invalid_move",
-                "message": "Not your turn",
-            })
-        }
-        return
-    }
-
-    if x < 0 || x >= 3 || y < 0 || y >= 3 {
-        log.Printf("Invalid coordinates from player %d: [%d,%d]", playerID, x, y)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "invalid_move",
-                "message": "Invalid coordinates",
-            })
-        }
-        return
-    }
-
-    if game.Board[x][y] != "" {
-        log.Printf("Cell [%d,%d] already occupied by %s", x, y, game.Board[x][y])
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "invalid_move",
-                "message": "Cell already occupied",
-            })
-        }
-        return
-    }
-
-    game.Board[x][y] = playerSymbol;
-    game.Turn = map[string]string{"X": "O", "O": "X"}[game.Turn];
-
-    _, err := db.DB.Exec(
-        "INSERT INTO moves (game_id, player_id, x, y, symbol) VALUES ($1, $2, $3, $4, $5)",
-        gameID, playerID, x, y, playerSymbol,
-    )
-    if err != nil {
-        log.Printf("Failed to save move for game %d: %v", gameID, err)
-    }
-
-    winner := game.Board.CheckWinner();
-    if winner != "" {
-        game.Status = "finished";
-        if winner == "X" {
-            game.WinnerID = game.Player1ID;
-        } else if (winner == "O" && game.Player2ID != 0) {
-            game.WinnerID = game.Player2ID;
-        }
-        log.Printf("Game %d finished. Winner: %s", gameID, winner);
-    } else {
-        isDraw := true;
-        for i := 0; i < 3; i++ {
-            for j := 0; j < 3; j++ {
-                if game.Board[i][j] == "" {
-                    isDraw = false;
-                    break;
-                }
-            }
-            if !isDraw {
-                break;
-            }
-        }
-        if isDraw {
-            game.Status = "finished";
-            log.Printf("Game %d finished in a draw", gameID);
-        }
-    }
-
-    if game.Status == "finished" && game.Player2ID != 0 {
-        if winner == "X" {
-            updatePlayerStats(game.Player1ID, "wins");
-            updatePlayerStats(game.Player2ID, "losses");
-        } else if winner == "O" {
-            updatePlayerStats(game.Player1ID, "losses");
-            updatePlayerStats(game.Player2ID, "wins");
-        } else {
-            updatePlayerStats(game.Player1ID, "draws");
-            updatePlayerStats(game.Player2ID, "draws");
-        }
-    }
-
-    boardJSON, _ := json.Marshal(game.Board);
-    _, err = db.DB.Exec(
-        "UPDATE games SET status=$1, turn=$2, board=$3, winner_id=$4, updated_at=$5 WHERE id=$6",
-        game.Status, game.Turn, boardJSON, game.WinnerID, time.Now(), gameID,
-    )
-    if err != nil {
-        log.Printf("Failed to update game %d: %v", gameID, err)
-    }
-
-    state := map[string]interface{}{
-        "type":   "move",
-        "board":  game.Board,
-        "turn":   game.Turn,
-        "status": game.Status,
-    }
-    if game.Status == "finished" {
-        state["winner"] = winner;
-    }
-
-    if client1, ok := gm.clients[game.Player1ID]; ok {
-        if err := client1.Conn.WriteJSON(state); err != nil {
-            log.Printf("Failed to send update to player %d: %v", game.Player1ID, err)
-        }
-    }
-
-    if game.Player2ID != 0 {
-        if client2, ok := gm.clients[game.Player2ID]; ok {
-            if err := client2.Conn.WriteJSON(state); err != nil {
-                log.Printf("Failed to send update to player %d: %v", game.Player2ID, err)
-            }
-        }
-    }
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	game, ok := gm.games[gameID]
+	if !ok {
+		log.Printf("Game %d not found for player %d", gameID, playerID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "Game not found",
+			})
+		}
+		return
+	}
+
+	if game.Player1ID != playerID && game.Player2ID != playerID {
+		log.Printf("Player %d is not part of game %d", playerID, gameID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "You are not part of this game",
+			})
+		}
+		return
+	}
+
+	playerSymbol := game.PlayerSymbol(playerID)
+
+	if game.Turn != playerSymbol {
+		log.Printf("Not player %d's turn (%s), current turn: %s", playerID, playerSymbol, game.Turn)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "invalid_move",
+				"message": "Not your turn",
+			})
+		}
+		return
+	}
+
+	rules := game.Rules()
+	move, err := rules.Apply(&game.Position, Move{X: x, Y: y})
+	if err != nil {
+		log.Printf("Invalid move from player %d in game %d: [%d,%d]: %v", playerID, gameID, x, y, err)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "invalid_move",
+				"message": err.Error(),
+			})
+		}
+		return
+	}
+	gm.saveMove(game, playerID, playerSymbol, move)
+	winner := gm.checkFinished(game)
+
+	state := map[string]interface{}{
+		"type":    "move",
+		"x":       move.X,
+		"y":       move.Y,
+		"removed": game.Removed,
+	}
+	// В оффлайн-партии ИИ отвечает сразу, и игрок получает оба хода одним сообщением
+	if game.IsAITurn() {
+		aiMove, err := gm.playAITurn(game)
+		if gm.games[gameID] != game {
+			// Пока ИИ думал, игрок отключился и партия закрыта
+			return
+		}
+		if err == nil {
+			state["aiMove"] = map[string]interface{}{
+				"x":       aiMove.X,
+				"y":       aiMove.Y,
+				"removed": game.Removed,
+			}
+			winner = gm.checkFinished(game)
+		}
+	}
+	gm.saveGame(game)
+
+	state["board"] = game.Board
+	state["turn"] = game.Turn
+	state["status"] = game.Status
+	state["activeBoard"] = game.ActiveBoard
+	if game.Status == "finished" {
+		state["winner"] = winner
+	}
+
+	if client1, ok := gm.clients[game.Player1ID]; ok {
+		if err := client1.Conn.WriteJSON(state); err != nil {
+			log.Printf("Failed to send update to player %d: %v", game.Player1ID, err)
+		}
+	}
+
+	if game.Player2ID != 0 {
+		if client2, ok := gm.clients[game.Player2ID]; ok {
+			if err := client2.Conn.WriteJSON(state); err != nil {
+				log.Printf("Failed to send update to player %d: %v", game.Player2ID, err)
+			}
+		}
+	}
+}
+
+// HandleHint отправляет игроку лучший ход в его партии и оценку позиции.
+// Подсказки доступны только в свой ход, не больше MaxHints за партию и не чаще HintCooldown.
+// Поиск идёт по копии позиции с отпущенной блокировкой
+func (gm *GameManager) HandleHint(gameID, playerID int) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	game, ok := gm.games[gameID]
+	if !ok || (game.Player1ID != playerID && game.Player2ID != playerID) {
+		log.Printf("Game %d not found for hint request from player %d", gameID, playerID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "Game not found",
+			})
+		}
+		return
+	}
+
+	var message string
+	switch {
+	case game.Status != "active":
+		message = "Game is not active"
+	case game.Turn != game.PlayerSymbol(playerID):
+		message = "Hints are only available on your turn"
+	case game.HintsUsed(playerID) >= MaxHints:
+		message = "No hints left"
+	case game.hintPending || time.Since(game.lastHintAt) < HintCooldown:
+		message = "Too many hint requests, try again later"
+	}
+	if message != "" {
+		log.Printf("Rejected hint for player %d in game %d: %s", playerID, gameID, message)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": message,
+			})
+		}
+		return
+	}
+
+	game.hintPending, game.lastHintAt = true, time.Now()
+	pos := game.Position.Clone()
+	gm.mu.Unlock()
+	move, eval, err := Hint(pos)
+	gm.mu.Lock()
+	game.hintPending = false
+
+	if err == nil && (gm.games[gameID] != game || game.Status != "active" || len(game.History) != len(pos.History)) {
+		err = errStaleHint
+	}
+	if err != nil {
+		log.Printf("Failed to find hint in game %d: %v", gameID, err)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "No hint available",
+			})
+		}
+		return
+	}
+
+	game.addHint(playerID)
+	_, err = db.DB.Exec(
+		"UPDATE games SET player1_hints=$1, player2_hints=$2, updated_at=$3 WHERE id=$4",
+		game.Player1Hints, game.Player2Hints, time.Now(), gameID,
+	)
+	if err != nil {
+		log.Printf("Failed to save hints for game %d: %v", gameID, err)
+	}
+	log.Printf("Player %d took hint %d in game %d: [%d,%d]", playerID, game.HintsUsed(playerID), gameID, move.X, move.Y)
+
+	if client, ok := gm.clients[playerID]; ok {
+		client.Conn.WriteJSON(map[string]interface{}{
+			"type":       "hint",
+			"gameID":     gameID,
+			"x":          move.X,
+			"y":          move.Y,
+			"evaluation": eval,
+			"hintsUsed":  game.HintsUsed(playerID),
+			"hintsLeft":  MaxHints - game.HintsUsed(playerID),
+		})
+	}
+}
+
+// HandleAIMove делает ход ИИ по запросу игрока. Запрос принимается, только если в оффлайн-партии
+// сейчас очередь ИИ — обычно ИИ отвечает сам в HandleMove и HandleSelectRole
+func (gm *GameManager) HandleAIMove(gameID, playerID int) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	game, ok := gm.games[gameID]
+	if !ok || game.Player1ID != playerID || game.Player2ID != 0 {
+		log.Printf("Offline game %d not found for AI move request from player %d", gameID, playerID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "Invalid game or not offline mode",
+			})
+		}
+		return
+	}
+
+	var message string
+	switch {
+	case !game.IsAITurn():
+		message = "Not the AI's turn"
+	case game.aiThinking:
+		message = "The AI is already thinking"
+	}
+	if message != "" {
+		log.Printf("Rejected AI move request from player %d in game %d: %s", playerID, gameID, message)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "invalid_move",
+				"message": message,
+			})
+		}
+		return
+	}
+
+	gm.replyAI(game)
+}
+
+// replyAI делает ход ИИ, сохраняет партию и отправляет игроку сообщение ai_move.
+// Вызывается под gm.mu, на время поиска хода отпускает его (см. playAITurn)
+func (gm *GameManager) replyAI(game *Game) {
+	move, err := gm.playAITurn(game)
+	if err == errStaleAIMove {
+		return
+	}
+	if err != nil {
+		if client, ok := gm.clients[game.Player1ID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "No available moves",
+			})
+		}
+		return
+	}
+	winner := gm.checkFinished(game)
+	gm.saveGame(game)
+
+	state := map[string]interface{}{
+		"type":        "ai_move",
+		"x":           move.X,
+		"y":           move.Y,
+		"board":       game.Board,
+		"turn":        game.Turn,
+		"status":      game.Status,
+		"activeBoard": game.ActiveBoard,
+		"removed":     game.Removed,
+	}
+	if game.Status == "finished" {
+		state["winner"] = winner
+	}
+	if client, ok := gm.clients[game.Player1ID]; ok {
+		if err := client.Conn.WriteJSON(state); err != nil {
+			log.Printf("Failed to send AI move to player %d: %v", game.Player1ID, err)
+		}
+	}
+}
+
+// playAITurn делает ход ИИ и записывает его в moves. Вызывается под gm.mu, но сам поиск
+// идёт по копии позиции с отпущенной блокировкой, чтобы долгий ход ИИ не останавливал
+// остальные партии. После поиска ход применяется, только если партия не изменилась
+func (gm *GameManager) playAITurn(game *Game) (Move, error) {
+	turn := game.aiTurn()
+	game.aiThinking = true
+	gm.mu.Unlock()
+	m, err := turn.search()
+	gm.mu.Lock()
+	game.aiThinking = false
+
+	if gm.games[game.ID] != game {
+		err = errStaleAIMove
+	} else if err == nil {
+		m, err = game.playAIMove(turn, m)
+	}
+	if err != nil {
+		log.Printf("AI move failed in game %d: %v", game.ID, err)
+		return Move{}, err
+	}
+	gm.saveMove(game, 0, turn.pos.Turn, m)
+	return m, nil
+}
+
+// saveMove записывает ход в moves. Ходы ИИ сохраняются с player_id = NULL
+func (gm *GameManager) saveMove(game *Game, playerID int, symbol string, move Move) {
+	_, err := db.DB.Exec(
+		"INSERT INTO moves (game_id, player_id, x, y, symbol, active_board) VALUES ($1, $2, $3, $4, $5, $6)",
+		game.ID, nullableID(playerID), move.X, move.Y, symbol, game.ActiveBoard,
+	)
+	if err != nil {
+		log.Printf("Failed to save move for game %d: %v", game.ID, err)
+	}
+}
+
+// checkFinished завершает партию, если после хода есть результат, и обновляет статистику.
+// Возвращает символ победителя или пустую строку
+func (gm *GameManager) checkFinished(game *Game) string {
+	result := game.Rules().Result(&game.Position)
+	winner := result.Winner
+	if winner != "" {
+		game.Status = "finished"
+		game.WinnerID = game.PlayerBySymbol(winner)
+		log.Printf("Game %d finished. Winner: %s", game.ID, winner)
+	} else if result.Finished {
+		game.Status = "finished"
+		log.Printf("Game %d finished in a draw", game.ID)
+	}
+
+	if game.Status == "finished" && game.HintedWin() {
+		log.Printf("Game %d was won with hints, not counted in stats", game.ID)
+	} else if game.Status == "finished" && game.Player2ID != 0 {
+		if err := UpdateRatings(game, winner); err != nil {
+			log.Printf("Failed to update ratings for game %d: %v", game.ID, err)
+		}
+	} else if game.Status == "finished" {
+		RecordOfflineResult(game, winner)
+	}
+	if game.Status == "finished" && game.Player2ID == 0 {
+		learnFromGame(game)
+	}
+	if game.Status == "finished" {
+		AnnotateInBackground(game.ID)
+	}
+	return winner
+}
+
+// learnFromGame передаёт завершённую оффлайн-партию движку, если он умеет учиться
+func learnFromGame(game *Game) {
+	engine, err := NewEngine(game.EngineName())
+	if err != nil {
+		return
+	}
+	learner, ok := engine.(Learner)
+	if !ok {
+		return
+	}
+	go func() {
+		if err := learner.Learn(game.ID); err != nil {
+			log.Printf("Engine %s failed to learn from game %d: %v", engine.Name(), game.ID, err)
+		}
+	}()
+}
+
+// saveGame сохраняет текущее состояние партии в games
+func (gm *GameManager) saveGame(game *Game) {
+	_, err := db.DB.Exec(
+		"UPDATE games SET status=$1, turn=$2, board=$3, winner_id=$4, updated_at=$5 WHERE id=$6",
+		game.Status, game.Turn, game.BoardJSON(), nullableID(game.WinnerID), time.Now(), game.ID,
+	)
+	if err != nil {
+		log.Printf("Failed to update game %d: %v", game.ID, err)
+	}
 }
 
 func (gm *GameManager) HandleRematchRequest(gameID, playerID int) {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-
-    game, ok := gm.games[gameID]
-    if !ok {
-        log.Printf("Game %d not found for rematch request from player %d", gameID, playerID)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "warning",
-                "message": "Game not found",
-            })
-        }
-        return
-    }
-
-    if game.Player1ID != playerID && game.Player2ID != playerID {
-        log.Printf("Player %d is not part of game %d", playerID, gameID)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "warning",
-                "message": "You are not part of this game",
-            })
-        }
-        return
-    }
-
-    if game.Status != "finished" {
-        log.Printf("Game %d is not finished, cannot request rematch", gameID)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "warning",
-                "message": "Game is not finished",
-            })
-        }
-        return
-    }
-
-    if _, ok := gm.rematchRequests[gameID]; !ok {
-        gm.rematchRequests[gameID] = make(map[int]bool)
-    }
-    gm.rematchRequests[gameID][playerID] = true
-
-    opponentID := game.Player1ID
-    if game.Player1ID == playerID {
-        opponentID = game.Player2ID
-    }
-
-    if client, ok := gm.clients[opponentID]; ok {
-        client.Conn.WriteJSON(map[string]interface{}{
-            "type": "rematch_request",
-            "gameID": gameID,
-        })
-        log.Printf("Sent rematch request to player %d for game %d", opponentID, gameID)
-    } else {
-        log.Printf("Opponent %d not found for rematch request in game %d", opponentID, gameID)
-    }
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	game, ok := gm.games[gameID]
+	if !ok {
+		log.Printf("Game %d not found for rematch request from player %d", gameID, playerID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "Game not found",
+			})
+		}
+		return
+	}
+
+	if game.Player1ID != playerID && game.Player2ID != playerID {
+		log.Printf("Player %d is not part of game %d", playerID, gameID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "You are not part of this game",
+			})
+		}
+		return
+	}
+
+	if game.Status != "finished" {
+		log.Printf("Game %d is not finished, cannot request rematch", gameID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "Game is not finished",
+			})
+		}
+		return
+	}
+
+	if _, ok := gm.rematchRequests[gameID]; !ok {
+		gm.rematchRequests[gameID] = make(map[int]bool)
+	}
+	gm.rematchRequests[gameID][playerID] = true
+
+	opponentID := game.Player1ID
+	if game.Player1ID == playerID {
+		opponentID = game.Player2ID
+	}
+
+	if client, ok := gm.clients[opponentID]; ok {
+		client.Conn.WriteJSON(map[string]interface{}{
+			"type":   "rematch_request",
+			"gameID": gameID,
+		})
+		log.Printf("Sent rematch request to player %d for game %d", opponentID, gameID)
+	} else {
+		log.Printf("Opponent %d not found for rematch request in game %d", opponentID, gameID)
+	}
 }
 
 func (gm *GameManager) HandleRematchResponse(gameID, playerID int, accepted bool) {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-
-    game, ok := gm.games[gameID]
-    if !ok {
-        log.Printf("Game %d not found for rematch response from player %d", gameID, playerID)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "warning",
-                "message": "Game not found",
-            })
-        }
-        return
-    }
-
-    if game.Player1ID != playerID && game.Player2ID != playerID {
-        log.Printf("Player %d is not part of game %d", playerID, gameID)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "warning",
-                "message": "You are not part of this game",
-            })
-        }
-        return
-    }
-
-    opponentID := game.Player1ID
-    if game.Player1ID == playerID {
-        opponentID = game.Player2ID
-    }
-
-    response := map[string]interface{}{
-        "type": "rematch_response",
-        "gameID": gameID,
-        "accepted": accepted,
-    }
-
-    // Уведомляем обоих игроков о решении
-    if client1, ok := gm.clients[playerID]; ok {
-        client1.Conn.WriteJSON(response)
-        log.Printf("Sent rematch response to player %d for game %d: %v", playerID, gameID, accepted)
-    }
-    if client2, ok := gm.clients[opponentID]; ok {
-        client2.Conn.WriteJSON(response)
-        log.Printf("Sent rematch response to opponent %d for game %d: %v", opponentID, gameID, accepted)
-    }
-
-    if accepted {
-        if requests, ok := gm.rematchRequests[gameID]; ok && requests[opponentID] {
-            // Оба игрока согласились, инициируем start_rematch
-            delete(gm.games, gameID)
-            delete(gm.rematchRequests, gameID)
-
-            // Отправляем start_rematch обоим игрокам
-            startRematchMsg := map[string]interface{}{
-                "type": "start_rematch",
-                "gameID": gameID,
-                "playerID": playerID,
-                "opponentID": opponentID,
-            }
-            if client1, ok := gm.clients[playerID]; ok {
-                client1.Conn.WriteJSON(startRematchMsg)
-            }
-            if client2, ok := gm.clients[opponentID]; ok {
-                client2.Conn.WriteJSON(startRematchMsg)
-            }
-        }
-    } else {
-        delete(gm.rematchRequests, gameID)
-    }
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	game, ok := gm.games[gameID]
+	if !ok {
+		log.Printf("Game %d not found for rematch response from player %d", gameID, playerID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "Game not found",
+			})
+		}
+		return
+	}
+
+	if game.Player1ID != playerID && game.Player2ID != playerID {
+		log.Printf("Player %d is not part of game %d", playerID, gameID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "You are not part of this game",
+			})
+		}
+		return
+	}
+
+	opponentID := game.Player1ID
+	if game.Player1ID == playerID {
+		opponentID = game.Player2ID
+	}
+
+	response := map[string]interface{}{
+		"type":     "rematch_response",
+		"gameID":   gameID,
+		"accepted": accepted,
+	}
+
+	// Уведомляем обоих игроков о решении
+	if client1, ok := gm.clients[playerID]; ok {
+		client1.Conn.WriteJSON(response)
+		log.Printf("Sent rematch response to player %d for game %d: %v", playerID, gameID, accepted)
+	}
+	if client2, ok := gm.clients[opponentID]; ok {
+		client2.Conn.WriteJSON(response)
+		log.Printf("Sent rematch response to opponent %d for game %d: %v", opponentID, gameID, accepted)
+	}
+
+	if accepted {
+		if requests, ok := gm.rematchRequests[gameID]; ok && requests[opponentID] {
+			// Оба игрока согласились, инициируем start_rematch
+			gm.rematches[gameID] = &rematch{
+				Player1ID: game.Player1ID,
+				Player2ID: game.Player2ID,
+				Options:   game.Options,
+				ExpiresAt: time.Now().Add(rematchTTL),
+			}
+			delete(gm.games, gameID)
+			delete(gm.rematchRequests, gameID)
+
+			// Отправляем start_rematch обоим игрокам
+			startRematchMsg := map[string]interface{}{
+				"type":       "start_rematch",
+				"gameID":     gameID,
+				"playerID":   playerID,
+				"opponentID": opponentID,
+			}
+			if client1, ok := gm.clients[playerID]; ok {
+				client1.Conn.WriteJSON(startRematchMsg)
+			}
+			if client2, ok := gm.clients[opponentID]; ok {
+				client2.Conn.WriteJSON(startRematchMsg)
+			}
+		}
+	} else {
+		delete(gm.rematchRequests, gameID)
+		delete(gm.rematches, gameID)
+	}
 }
 
 func (gm *GameManager) StartRematch(gameID, playerID, opponentID int) {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-
-    game, ok := gm.games[gameID]
-    if !ok {
-        log.Printf("Game %d not found for rematch start from player %d", gameID, playerID)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "warning",
-                "message": "Game not found",
-            })
-        }
-        return
-    }
-
-    if (game.Player1ID != playerID || game.Player2ID != opponentID) && (game.Player2ID != playerID || game.Player1ID != opponentID) {
-        log.Printf("Invalid player-opponent pair for rematch in game %d", gameID)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "warning",
-                "message": "Invalid rematch request",
-            })
-        }
-        return
-    }
-
-    if requests, ok := gm.rematchRequests[gameID]; !ok || !requests[playerID] || !requests[opponentID] {
-        log.Printf("Rematch not confirmed by both players for game %d", gameID)
-        if client, ok := gm.clients[playerID]; ok {
-            client.Conn.WriteJSON(map[string]interface{}{
-                "type":    "warning",
-                "message": "Rematch not confirmed by both players",
-            })
-        }
-        return
-    }
-
-    delete(gm.games, gameID)
-    delete(gm.rematchRequests, gameID)
-    gm.CreateRematch(game.Player1ID, game.Player2ID)
-}
-
-func updatePlayerStats(playerID int, result string) {
-    var query string;
-    switch result {
-    case "wins":
-        query = "UPDATE offline_stats SET wins = wins + 1, updated_at = $2 WHERE player_id = $1"
-    case "losses":
-        query = "UPDATE offline_stats SET losses = losses + 1, updated_at = $2 WHERE player_id = $1"
-    case "draws":
-        query = "UPDATE offline_stats SET draws = draws + 1, updated_at = $2 WHERE player_id = $1"
-    }
-
-    _, err := db.DB.Exec(query, playerID, time.Now())
-    if err != nil {
-        log.Printf("Failed to update stats for player %d: %v", playerID, err)
-        _, err = db.DB.Exec(
-            "INSERT INTO offline_stats (player_id, wins, losses, draws) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
-            playerID, 0, 0, 0,
-        )
-        if err != nil {
-            log.Printf("Failed to create stats for player %d: %v", playerID, err)
-        }
-        _, err = db.DB.Exec(query, playerID, time.Now())
-        if err != nil {
-            log.Printf("Failed to update stats after creation for player %d: %v", playerID, err)
-        }
-    }
-}
-
-func (gm *GameManager) HandleDisconnect(playerID int) {
-    gm.mu.Lock()
-    defer gm.mu.Unlock()
-
-    delete(gm.clients, playerID)
-    for gameID, game := range gm.games {
-        if game.Player1ID == playerID || game.Player2ID == playerID {
-            game.Status = "finished"
-            opponentID := game.Player1ID
-            if game.Player1ID == playerID {
-                opponentID = game.Player2ID
-            }
-            if client, ok := gm.clients[opponentID]; ok {
-                client.Conn.WriteJSON(map[string]interface{}{
-                    "type":    "opponent_left",
-                    "message": "Opponent has disconnected",
-                })
-            }
-            delete(gm.games, gameID)
-            delete(gm.rematchRequests, gameID)
-
-            _, err := db.DB.Exec(
-                "UPDATE games SET status=$1, updated_at=$2 WHERE id=$3",
-                game.Status, time.Now(), gameID,
-            )
-            if err != nil {
-                log.Printf("Failed to update game %d on disconnect: %v", gameID, err)
-            }
-            break
-        }
-    }
-}
\ No newline at end of file
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	game, ok := gm.games[gameID]
+	if !ok {
+		log.Printf("Game %d not found for rematch start from player %d", gameID, playerID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "Game not found",
+			})
+		}
+		return
+	}
+
+	if (game.Player1ID != playerID || game.Player2ID != opponentID) && (game.Player2ID != playerID || game.Player1ID != opponentID) {
+		log.Printf("Invalid player-opponent pair for rematch in game %d", gameID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "Invalid rematch request",
+			})
+		}
+		return
+	}
+
+	if requests, ok := gm.rematchRequests[gameID]; !ok || !requests[playerID] || !requests[opponentID] {
+		log.Printf("Rematch not confirmed by both players for game %d", gameID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "Rematch not confirmed by both players",
+			})
+		}
+		return
+	}
+
+	delete(gm.games, gameID)
+	delete(gm.rematchRequests, gameID)
+	if _, err := gm.createGame(NewGame(0, game.Player1ID, game.Player2ID, game.Options)); err != nil {
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": err.Error(),
+			})
+		}
+	}
+}
+
+// HandleSelectRole назначает человеку сторону в оффлайн-партии, пока не сделан первый ход.
+// Если первым теперь ходит ИИ, он сразу делает ход
+func (gm *GameManager) HandleSelectRole(gameID, playerID int, role string) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	game, ok := gm.games[gameID]
+	if !ok || game.Player1ID != playerID {
+		log.Printf("Game %d not found for role selection from player %d", gameID, playerID)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "Game not found",
+			})
+		}
+		return
+	}
+
+	var message string
+	switch {
+	case game.Player2ID != 0:
+		message = "Role selection is only available against the AI"
+	case role != "X" && role != "O":
+		message = "Invalid role"
+	case len(game.History) > 0 || game.aiThinking:
+		message = "Role can only be selected before the first move"
+	}
+	if message != "" {
+		log.Printf("Rejected role %q for player %d in game %d: %s", role, playerID, gameID, message)
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": message,
+			})
+		}
+		return
+	}
+
+	game.AISymbol = nextTurn(role)
+	log.Printf("Player %d plays %s against the AI in game %d", playerID, role, gameID)
+	if client, ok := gm.clients[playerID]; ok {
+		client.Conn.WriteJSON(map[string]interface{}{
+			"type":   "role_selected",
+			"gameID": gameID,
+			"role":   role,
+			"turn":   game.Turn,
+		})
+	}
+	if game.IsAITurn() {
+		gm.replyAI(game)
+	}
+}
+
+// RecordOfflineResult записывает итог партии против ИИ в статистику человека по уровню сложности.
+// Партии против явно выбранного движка учитываются под его именем
+func RecordOfflineResult(game *Game, winner string) {
+	column := "draws"
+	if winner != "" {
+		column = "losses"
+		if winner == game.PlayerSymbol(game.Player1ID) {
+			column = "wins"
+		}
+	}
+	game.WinnerID = 0
+	if winner != "" {
+		game.WinnerID = game.PlayerBySymbol(winner)
+	}
+
+	difficulty := game.EngineName()
+	_, err := db.DB.Exec(
+		"INSERT INTO offline_stats (player_id, difficulty, "+column+", updated_at) VALUES ($1, $2, 1, $3) "+
+			"ON CONFLICT (player_id, difficulty) DO UPDATE SET "+column+" = offline_stats."+column+" + 1, updated_at = EXCLUDED.updated_at",
+		game.Player1ID, difficulty, time.Now(),
+	)
+	if err != nil {
+		log.Printf("Failed to update offline stats for player %d: %v", game.Player1ID, err)
+	}
+}
+
+// nullableID переводит отсутствующий ID (0) в NULL для колонок со ссылкой на users
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// HandleDisconnect обрабатывает закрытие соединения conn. Если игрок уже переподключился,
+// закрылось старое соединение, и ни очередь, ни партии трогать не нужно
+func (gm *GameManager) HandleDisconnect(playerID int, conn *websocket.Conn) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	if client, ok := gm.clients[playerID]; ok && client.Conn != conn {
+		return
+	}
+	delete(gm.clients, playerID)
+	if gm.removeWaiting(playerID) {
+		log.Printf("Player %d disconnected and was removed from the waiting list", playerID)
+	}
+	gm.removeHostedRooms(playerID)
+	// У игрока с постоянным токеном рядом с текущей партией могут лежать завершённые,
+	// которые ждут реванша, поэтому просматриваем все его партии
+	for gameID, game := range gm.games {
+		if game.Player1ID != playerID && game.Player2ID != playerID {
+			continue
+		}
+		delete(gm.games, gameID)
+		delete(gm.rematchRequests, gameID)
+		delete(gm.rematches, gameID)
+		if game.Status == "finished" {
+			continue
+		}
+
+		opponentID := game.Player1ID
+		if game.Player1ID == playerID {
+			opponentID = game.Player2ID
+		}
+		// Кто ушёл из начатой онлайн-партии, проигрывает, иначе проигрывающий мог бы выйти
+		// и сохранить рейтинг. Партия, в которой ещё не сходили оба, просто отменяется
+		forfeit := game.Status == "active" && game.Player2ID != 0 && len(game.History) >= 2
+		game.Status = "finished"
+		message := map[string]interface{}{
+			"type":    "opponent_left",
+			"gameID":  gameID,
+			"message": "Opponent has disconnected",
+		}
+		if forfeit {
+			game.WinnerID = opponentID
+			message["winner"] = game.PlayerSymbol(opponentID)
+			if game.HintedWin() {
+				log.Printf("Game %d was won with hints, not counted in stats", gameID)
+			} else if err := UpdateRatings(game, game.PlayerSymbol(opponentID)); err != nil {
+				log.Printf("Failed to update ratings for game %d: %v", gameID, err)
+			}
+			log.Printf("Player %d left game %d and lost by forfeit", playerID, gameID)
+		}
+		if client, ok := gm.clients[opponentID]; ok {
+			client.Conn.WriteJSON(message)
+		}
+
+		_, err := db.DB.Exec(
+			"UPDATE games SET status=$1, winner_id=$2, updated_at=$3 WHERE id=$4",
+			game.Status, nullableID(game.WinnerID), time.Now(), gameID,
+		)
+		if err != nil {
+			log.Printf("Failed to update game %d on disconnect: %v", gameID, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package game
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"tictactoe/db"
+)
+
+// offlineDB направляет db.DB туда, где нет сервера: запросы сразу падают с ошибкой,
+// и код, который только пишет в БД, можно проверять без Postgres
+func offlineDB(t *testing.T) {
+	t.Helper()
+	conn, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := db.DB
+	db.DB = conn
+	t.Cleanup(func() {
+		conn.Close()
+		db.DB = old
+	})
+}
+
+func TestCreateRematchRequiresAgreement(t *testing.T) {
+	gm := NewGameManager()
+	gm.rematches[7] = &rematch{Player1ID: 1, Player2ID: 2, Options: DefaultOptions(), ExpiresAt: time.Now().Add(rematchTTL)}
+
+	tests := []struct {
+		name             string
+		gameID           int
+		player, opponent int
+	}{
+		{"no agreed rematch", 8, 1, 2},
+		{"outsider", 7, 3, 2},
+		{"other opponent", 7, 1, 3},
+	}
+	for _, tt := range tests {
+		if _, _, err := gm.CreateRematch(tt.gameID, tt.player, tt.opponent); !errors.Is(err, ErrNoRematch) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, ErrNoRematch)
+		}
+	}
+	if len(gm.games) != 0 {
+		t.Fatalf("%d games created without an agreed rematch", len(gm.games))
+	}
+}
+
+func TestHandleDisconnectForfeitsActiveGame(t *testing.T) {
+	offlineDB(t)
+	// Порядок обхода map случаен: завершённая партия не должна заслонять текущую
+	for i := 0; i < 20; i++ {
+		gm := NewGameManager()
+		finished := NewGame(1, 1, 2, DefaultOptions())
+		finished.Status = "finished"
+		active := NewGame(2, 1, 3, DefaultOptions())
+		active.History = []Move{{X: 0, Y: 0}, {X: 1, Y: 1}}
+		gm.games[finished.ID], gm.games[active.ID] = finished, active
+
+		gm.HandleDisconnect(1, nil)
+		if len(gm.games) != 0 {
+			t.Fatalf("%d games left after the player disconnected", len(gm.games))
+		}
+		if active.Status != "finished" || active.WinnerID != 3 {
+			t.Fatalf("active game: status %q, winner %d, want finished with winner 3", active.Status, active.WinnerID)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package game
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"tictactoe/utils"
+)
+
+const (
+	// matchBaseWindow — допустимая разница рейтингов для только что вставшего в очередь игрока
+	matchBaseWindow = 100.0
+	// matchWindowGrowth — на сколько окно расширяется за каждую секунду ожидания
+	matchWindowGrowth = 20.0
+	// matchMaxWindow — шире окно не становится
+	matchMaxWindow = 1000.0
+	// DefaultMatchInterval — как часто фоновый подборщик перебирает очередь
+	DefaultMatchInterval = time.Second
+	// DefaultMaxQueueWait — сколько по умолчанию можно ждать соперника
+	DefaultMaxQueueWait = 5 * time.Minute
+	// queueStatusInterval — как часто ожидающим отправляется queue_status
+	queueStatusInterval = 5 * time.Second
+	// recentWaitsSize — по скольким последним подборам оценивается время ожидания
+	recentWaitsSize = 20
+	// DefaultAIFallbackAfter — через сколько ожидания по умолчанию предлагается игра с ИИ
+	DefaultAIFallbackAfter = 30 * time.Second
+)
+
+// matchWindow — допустимая разница рейтингов для игрока, который ждёт waited
+func matchWindow(waited time.Duration) float64 {
+	window := matchBaseWindow + matchWindowGrowth*waited.Seconds()
+	if window > matchMaxWindow {
+		return matchMaxWindow
+	}
+	return window
+}
+
+// RunMatchmaker в фоне подбирает пары из очереди, закрывает просроченные комнаты и забывает
+// несостоявшиеся реванши, пока не закроется stop
+func (gm *GameManager) RunMatchmaker(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			gm.mu.Lock()
+			gm.matchWaiting(now)
+			gm.offerAI(now)
+			gm.expireWaiting(now)
+			gm.expireRooms(now)
+			gm.expireRematches(now)
+			if now.Sub(gm.lastQueueStatus) >= queueStatusInterval {
+				gm.sendQueueStatus(now)
+				gm.lastQueueStatus = now
+			}
+			gm.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// matchCandidate — пара игроков из очереди (индексы в очереди), которых можно свести
+type matchCandidate struct {
+	i, j int
+	diff float64
+}
+
+// matchCandidates возвращает пары игроков с одинаковыми параметрами партии, начиная с самых
+// близких по рейтингу. Пара подходит, если разница рейтингов укладывается в окно того
+// из двоих, кто ждёт дольше
+func matchCandidates(waiting []waitingPlayer, now time.Time) []matchCandidate {
+	var candidates []matchCandidate
+	for i := range waiting {
+		for j := i + 1; j < len(waiting); j++ {
+			a, b := waiting[i], waiting[j]
+			if a.Options != b.Options {
+				continue
+			}
+			diff := a.Rating - b.Rating
+			if diff < 0 {
+				diff = -diff
+			}
+			waited := now.Sub(a.Since)
+			if other := now.Sub(b.Since); other > waited {
+				waited = other
+			}
+			if diff <= matchWindow(waited) {
+				candidates = append(candidates, matchCandidate{i, j, diff})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(x, y int) bool { return candidates[x].diff < candidates[y].diff })
+	return candidates
+}
+
+// matchWaiting сводит подходящие пары из очереди (см. matchCandidates).
+// Вызывается под gm.mu, возвращает созданные партии
+func (gm *GameManager) matchWaiting(now time.Time) []*Game {
+	candidates := matchCandidates(gm.waiting, now)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	matched := make(map[int]bool)
+	var games []*Game
+	for _, c := range candidates {
+		if matched[c.i] || matched[c.j] {
+			continue
+		}
+		// Первым ходит тот, кто встал в очередь позже, как и раньше при подборе по запросу
+		first, second := gm.waiting[c.j], gm.waiting[c.i]
+		game, err := gm.createGame(NewGame(0, first.PlayerID, second.PlayerID, first.Options))
+		if err != nil {
+			// Оба остаются в очереди, подбор повторится на следующем тике
+			continue
+		}
+		matched[c.i], matched[c.j] = true, true
+		gm.recordWait(now.Sub(gm.waiting[c.i].Since))
+		gm.recordWait(now.Sub(gm.waiting[c.j].Since))
+		log.Printf("Matched players %d (%.0f) and %d (%.0f) in game %d", first.PlayerID, first.Rating, second.PlayerID, second.Rating, game.ID)
+		games = append(games, game)
+
+		go func() {
+			time.Sleep(1000 * time.Millisecond)
+			gm.NotifyPlayers(game)
+		}()
+	}
+
+	waiting := gm.waiting[:0]
+	for i, w := range gm.waiting {
+		if !matched[i] {
+			waiting = append(waiting, w)
+		}
+	}
+	gm.waiting = waiting
+	return games
+}
+
+// LeaveQueue убирает игрока из очереди по его запросу
+func (gm *GameManager) LeaveQueue(playerID int) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	if !gm.removeWaiting(playerID) {
+		if client, ok := gm.clients[playerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": "You are not in the queue",
+			})
+		}
+		return
+	}
+	log.Printf("Player %d left the waiting list", playerID)
+	if client, ok := gm.clients[playerID]; ok {
+		client.Conn.WriteJSON(map[string]interface{}{
+			"type": "queue_left",
+		})
+	}
+}
+
+// removeWaiting убирает игрока из очереди. Вызывается под gm.mu
+func (gm *GameManager) removeWaiting(playerID int) bool {
+	for i, w := range gm.waiting {
+		if w.PlayerID == playerID {
+			gm.waiting = append(gm.waiting[:i], gm.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// expireWaiting убирает из очереди тех, кто ждёт дольше MaxQueueWait. Вызывается под gm.mu
+func (gm *GameManager) expireWaiting(now time.Time) {
+	if gm.MaxQueueWait <= 0 {
+		return
+	}
+	waiting := gm.waiting[:0]
+	for _, w := range gm.waiting {
+		if now.Sub(w.Since) < gm.MaxQueueWait {
+			waiting = append(waiting, w)
+			continue
+		}
+		log.Printf("Player %d waited %s and was removed from the waiting list", w.PlayerID, gm.MaxQueueWait)
+		if client, ok := gm.clients[w.PlayerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "queue_timeout",
+				"message": "No opponent found",
+			})
+		}
+	}
+	gm.waiting = waiting
+}
+
+// sendQueueStatus сообщает каждому ожидающему его место в очереди среди игроков
+// с теми же параметрами партии и оценку оставшегося ожидания. Вызывается под gm.mu
+func (gm *GameManager) sendQueueStatus(now time.Time) {
+	positions := make(map[Options]int)
+	for _, w := range gm.waiting {
+		positions[w.Options]++
+		client, ok := gm.clients[w.PlayerID]
+		if !ok {
+			continue
+		}
+		waited := now.Sub(w.Since)
+		status := map[string]interface{}{
+			"type":     "queue_status",
+			"position": positions[w.Options],
+			"waiting":  len(gm.waiting),
+			"waited":   int(waited.Seconds()),
+		}
+		if estimate, ok := gm.estimatedWait(waited); ok {
+			status["estimatedWait"] = int(estimate.Seconds())
+		}
+		if err := client.Conn.WriteJSON(status); err != nil {
+			log.Printf("Failed to send queue status to player %d: %v", w.PlayerID, err)
+		}
+	}
+}
+
+// estimatedWait оценивает оставшееся ожидание по среднему времени последних подборов.
+// ok == false, если подборов ещё не было
+func (gm *GameManager) estimatedWait(waited time.Duration) (time.Duration, bool) {
+	if len(gm.recentWaits) == 0 {
+		return 0, false
+	}
+	var total time.Duration
+	for _, w := range gm.recentWaits {
+		total += w
+	}
+	remaining := total/time.Duration(len(gm.recentWaits)) - waited
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+func (gm *GameManager) recordWait(wait time.Duration) {
+	gm.recentWaits = append(gm.recentWaits, wait)
+	if len(gm.recentWaits) > recentWaitsSize {
+		gm.recentWaits = gm.recentWaits[len(gm.recentWaits)-recentWaitsSize:]
+	}
+}
+
+// offerAI предлагает сыграть с ИИ тем, кто ждёт дольше AIFallbackAfter, а тем, кто
+// заранее согласился, сразу начинает такую партию. Вызывается под gm.mu
+func (gm *GameManager) offerAI(now time.Time) {
+	if gm.AIFallbackAfter <= 0 {
+		return
+	}
+	waiting := gm.waiting[:0]
+	var started []waitingPlayer
+	for _, w := range gm.waiting {
+		if now.Sub(w.Since) < gm.AIFallbackAfter {
+			waiting = append(waiting, w)
+			continue
+		}
+		if w.AutoAI {
+			started = append(started, w)
+			continue
+		}
+		if !w.Offered {
+			w.Offered = true
+			if client, ok := gm.clients[w.PlayerID]; ok {
+				client.Conn.WriteJSON(map[string]interface{}{
+					"type":    "ai_offer",
+					"message": "No opponent found yet. Play against the AI?",
+				})
+			}
+		}
+		waiting = append(waiting, w)
+	}
+	gm.waiting = waiting
+	for _, w := range started {
+		gm.startAIGame(w)
+	}
+}
+
+// AcceptAIOffer убирает игрока из очереди и начинает для него партию с ИИ
+func (gm *GameManager) AcceptAIOffer(playerID int) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	for _, w := range gm.waiting {
+		if w.PlayerID == playerID {
+			gm.removeWaiting(playerID)
+			gm.startAIGame(w)
+			return
+		}
+	}
+	if client, ok := gm.clients[playerID]; ok {
+		client.Conn.WriteJSON(map[string]interface{}{
+			"type":    "warning",
+			"message": "You are not in the queue",
+		})
+	}
+}
+
+// startAIGame начинает партию с ИИ для игрока из очереди: сложность подбирается по рейтингу,
+// а ИИ показывается под обычным ником. Вызывается под gm.mu
+func (gm *GameManager) startAIGame(w waitingPlayer) {
+	game, err := gm.createOfflineGame(w.PlayerID, w.Options, DifficultyForRating(w.Rating), "")
+	if err != nil {
+		if client, ok := gm.clients[w.PlayerID]; ok {
+			client.Conn.WriteJSON(map[string]interface{}{
+				"type":    "warning",
+				"message": err.Error(),
+			})
+		}
+		return
+	}
+	game.BotNickname = utils.GenerateNickname()
+	game.BotID = BotPlayerID(game.Difficulty)
+	log.Printf("Player %d plays AI %s (%s) in game %d after waiting %s", w.PlayerID, game.BotNickname, game.Difficulty, game.ID, time.Since(w.Since).Round(time.Second))
+	go gm.NotifyPlayers(game)
+}
@@ -0,0 +1,153 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchWindow(t *testing.T) {
+	tests := []struct {
+		waited time.Duration
+		want   float64
+	}{
+		{0, matchBaseWindow},
+		{5 * time.Second, matchBaseWindow + 5*matchWindowGrowth},
+		{30 * time.Second, matchBaseWindow + 30*matchWindowGrowth},
+		{time.Hour, matchMaxWindow},
+	}
+	for _, tt := range tests {
+		if got := matchWindow(tt.waited); got != tt.want {
+			t.Errorf("matchWindow(%s) = %.0f, want %.0f", tt.waited, got, tt.want)
+		}
+	}
+}
+
+func TestMatchCandidates(t *testing.T) {
+	now := time.Now()
+	classic := DefaultOptions()
+	large := Options{Variant: DefaultVariant, Size: 5, WinLength: 4}
+
+	tests := []struct {
+		name    string
+		waiting []waitingPlayer
+		want    [][2]int // пары индексов в порядке подбора
+	}{
+		{
+			name: "close ratings match at once",
+			waiting: []waitingPlayer{
+				{PlayerID: 1, Options: classic, Rating: 1500, Since: now},
+				{PlayerID: 2, Options: classic, Rating: 1550, Since: now},
+			},
+			want: [][2]int{{0, 1}},
+		},
+		{
+			name: "distant ratings wait for the window to widen",
+			waiting: []waitingPlayer{
+				{PlayerID: 1, Options: classic, Rating: 1500, Since: now},
+				{PlayerID: 2, Options: classic, Rating: 1800, Since: now.Add(-5 * time.Second)},
+			},
+		},
+		{
+			name: "the longer wait widens the window for the pair",
+			waiting: []waitingPlayer{
+				{PlayerID: 1, Options: classic, Rating: 1500, Since: now},
+				{PlayerID: 2, Options: classic, Rating: 1800, Since: now.Add(-10 * time.Second)},
+			},
+			want: [][2]int{{0, 1}},
+		},
+		{
+			name: "different board options never match",
+			waiting: []waitingPlayer{
+				{PlayerID: 1, Options: classic, Rating: 1500, Since: now.Add(-time.Hour)},
+				{PlayerID: 2, Options: large, Rating: 1500, Since: now.Add(-time.Hour)},
+			},
+		},
+		{
+			name: "closest ratings first",
+			waiting: []waitingPlayer{
+				{PlayerID: 1, Options: classic, Rating: 1500, Since: now},
+				{PlayerID: 2, Options: classic, Rating: 1590, Since: now},
+				{PlayerID: 3, Options: classic, Rating: 1520, Since: now},
+			},
+			want: [][2]int{{0, 2}, {1, 2}, {0, 1}},
+		},
+	}
+	for _, tt := range tests {
+		got := matchCandidates(tt.waiting, now)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d candidates, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for k, c := range got {
+			if c.i != tt.want[k][0] || c.j != tt.want[k][1] {
+				t.Errorf("%s: candidate %d is (%d, %d), want %v", tt.name, k, c.i, c.j, tt.want[k])
+			}
+		}
+	}
+}
+
+func TestOfferAI(t *testing.T) {
+	offlineDB(t)
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		player      waitingPlayer
+		wantQueued  bool
+		wantOffered bool
+	}{
+		{"before the threshold", waitingPlayer{PlayerID: 1, Since: now.Add(-10 * time.Second)}, true, false},
+		{"after the threshold", waitingPlayer{PlayerID: 1, Since: now.Add(-40 * time.Second)}, true, true},
+		{"opted in, before the threshold", waitingPlayer{PlayerID: 1, Since: now.Add(-10 * time.Second), AutoAI: true}, true, false},
+		// Партия с ИИ начинается сразу, поэтому игрок уходит из очереди
+		{"opted in, after the threshold", waitingPlayer{PlayerID: 1, Since: now.Add(-40 * time.Second), AutoAI: true}, false, false},
+	}
+	for _, tt := range tests {
+		gm := NewGameManager()
+		gm.AIFallbackAfter = 30 * time.Second
+		tt.player.Options = DefaultOptions()
+		gm.waiting = []waitingPlayer{tt.player}
+
+		gm.offerAI(now)
+		if queued := len(gm.waiting) == 1; queued != tt.wantQueued {
+			t.Errorf("%s: queued = %v, want %v", tt.name, queued, tt.wantQueued)
+			continue
+		}
+		if tt.wantQueued && gm.waiting[0].Offered != tt.wantOffered {
+			t.Errorf("%s: offered = %v, want %v", tt.name, gm.waiting[0].Offered, tt.wantOffered)
+		}
+	}
+
+	gm := NewGameManager()
+	gm.AIFallbackAfter = 0
+	gm.waiting = []waitingPlayer{{PlayerID: 1, Since: now.Add(-time.Hour), AutoAI: true}}
+	gm.offerAI(now)
+	if len(gm.waiting) != 1 || gm.waiting[0].Offered {
+		t.Error("AIFallbackAfter = 0 should disable the AI fallback")
+	}
+}
+
+func TestExpireWaiting(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		maxWait    time.Duration
+		waited     time.Duration
+		wantQueued bool
+	}{
+		{"within the limit", 5 * time.Minute, 4 * time.Minute, true},
+		{"at the limit", 5 * time.Minute, 5 * time.Minute, false},
+		{"over the limit", 5 * time.Minute, time.Hour, false},
+		{"no limit", 0, time.Hour, true},
+	}
+	for _, tt := range tests {
+		gm := NewGameManager()
+		gm.MaxQueueWait = tt.maxWait
+		gm.waiting = []waitingPlayer{{PlayerID: 1, Options: DefaultOptions(), Since: now.Add(-tt.waited)}}
+
+		gm.expireWaiting(now)
+		if queued := len(gm.waiting) == 1; queued != tt.wantQueued {
+			t.Errorf("%s: queued = %v, want %v", tt.name, queued, tt.wantQueued)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package game
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultExploration — константа UCT, sqrt(2)
+	DefaultExploration = 1.41
+	// mctsLargeBoard — с какого числа клеток движки уровней сложности переходят с Minimax на MCTS
+	mctsLargeBoard = 49
+	// mctsBranching — сколько лучших по lineScore ходов раскрывается в узле
+	mctsBranching = 12
+	// greedyPlayoutChance — доля ходов доигрывания, выбираемых по lineScore, а не случайно
+	greedyPlayoutChance = 0.9
+)
+
+// MCTS — поиск Монте-Карло по дереву (UCT) со случайными доигрываниями.
+// Поиск останавливается по исчерпании Iterations или TimeLimit (что наступит раньше,
+// нулевое значение — без ограничения). С фиксированным Seed и без TimeLimit результат детерминирован
+type MCTS struct {
+	Iterations  int
+	TimeLimit   time.Duration
+	Exploration float64
+	Seed        int64 // 0 — случайное зерно
+
+	rng *rand.Rand
+}
+
+type mctsNode struct {
+	move     Move
+	player   string // кто сделал ход, ведущий в этот узел
+	parent   *mctsNode
+	children []*mctsNode
+	untried  []Move
+	visits   int
+	score    float64 // сумма результатов с точки зрения player: победа 1, ничья 0.5
+}
+
+func NewMCTS(iterations int, timeLimit time.Duration, seed int64) *MCTS {
+	return &MCTS{Iterations: iterations, TimeLimit: timeLimit, Exploration: DefaultExploration, Seed: seed}
+}
+
+// Search возвращает самый посещаемый ход для стороны p.Turn и долю его выигрышей (0..1).
+// ok == false, если ходов нет
+func (s *MCTS) Search(p *Position) (Move, float64, bool) {
+	rules := p.Rules()
+	if s.rng == nil {
+		seed := s.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		s.rng = rand.New(rand.NewSource(seed))
+	}
+	if s.Iterations <= 0 && s.TimeLimit <= 0 {
+		s.Iterations = 1000
+	}
+
+	root := &mctsNode{player: nextTurn(p.Turn), untried: s.expansionMoves(rules, p)}
+	if len(root.untried) == 0 {
+		return Move{}, 0, false
+	}
+	// Случайные доигрывания плохо видят выигрыш в один ход, поэтому проверяем его отдельно
+	if m, ok := immediateWin(rules, p, p.Turn, root.untried); ok {
+		return m, 1, true
+	}
+	if m, ok := immediateWin(rules, p, nextTurn(p.Turn), root.untried); ok {
+		return m, 0.5, true
+	}
+
+	deadline := time.Now().Add(s.TimeLimit)
+	for i := 0; s.Iterations <= 0 || i < s.Iterations; i++ {
+		if s.TimeLimit > 0 && time.Now().After(deadline) {
+			break
+		}
+		node, pos := root, p.Clone()
+
+		// Выбор: спускаемся по UCT, пока узел полностью раскрыт
+		for len(node.untried) == 0 && len(node.children) > 0 {
+			node = s.selectChild(node)
+			rules.Apply(pos, node.move)
+		}
+
+		// Расширение: добавляем следующий по порядку ещё не опробованный ход
+		if len(node.untried) > 0 {
+			m := node.untried[0]
+			node.untried = node.untried[1:]
+			mover := pos.Turn
+			if _, err := rules.Apply(pos, m); err == nil {
+				child := &mctsNode{move: m, player: mover, parent: node, untried: s.expansionMoves(rules, pos)}
+				node.children = append(node.children, child)
+				node = child
+			}
+		}
+
+		// Доигрывание и обратное распространение результата
+		winner := s.playout(rules, pos)
+		for ; node != nil; node = node.parent {
+			node.visits++
+			switch winner {
+			case "":
+				node.score += 0.5
+			case node.player:
+				node.score++
+			}
+		}
+	}
+
+	var best *mctsNode
+	for _, child := range root.children {
+		if best == nil || child.visits > best.visits {
+			best = child
+		}
+	}
+	if best == nil {
+		return root.untried[0], 0.5, true
+	}
+	return best.move, best.score / float64(best.visits), true
+}
+
+func (s *MCTS) selectChild(node *mctsNode) *mctsNode {
+	var best *mctsNode
+	bestValue := math.Inf(-1)
+	logVisits := math.Log(float64(node.visits))
+	for _, child := range node.children {
+		value := child.score/float64(child.visits) + s.Exploration*math.Sqrt(logVisits/float64(child.visits))
+		if value > bestValue {
+			best, bestValue = child, value
+		}
+	}
+	return best
+}
+
+// usesLinePolicy сообщает, подходит ли lineScore для позиции: в misère линии вредны,
+// а в ultimate линии на общей доске 9×9 не совпадают с линиями малых досок
+func usesLinePolicy(p *Position) bool {
+	return !p.Misere && p.Variant != "ultimate"
+}
+
+// expansionMoves возвращает ходы для раскрытия узла: лучшие по lineScore
+// или, если эвристика не подходит варианту, все кандидаты в случайном порядке
+func (s *MCTS) expansionMoves(rules Ruleset, p *Position) []Move {
+	moves := candidateMoves(rules, p)
+	if !usesLinePolicy(p) {
+		s.rng.Shuffle(len(moves), func(i, j int) { moves[i], moves[j] = moves[j], moves[i] })
+		return moves
+	}
+	scores := make(map[Move]int, len(moves))
+	for _, m := range moves {
+		scores[m] = lineScore(p, m)
+	}
+	sort.SliceStable(moves, func(i, j int) bool { return scores[moves[i]] > scores[moves[j]] })
+	if len(moves) > mctsBranching {
+		moves = moves[:mctsBranching]
+	}
+	return moves
+}
+
+// playoutMove выбирает ход доигрывания: чаще всего лучший по lineScore, иначе случайный
+func (s *MCTS) playoutMove(p *Position, moves []Move) Move {
+	if !usesLinePolicy(p) || s.rng.Float64() >= greedyPlayoutChance {
+		return moves[s.rng.Intn(len(moves))]
+	}
+	best, bestScore, ties := moves[0], -1, 0
+	for _, m := range moves {
+		score := lineScore(p, m)
+		if score > bestScore {
+			best, bestScore, ties = m, score, 1
+		} else if score == bestScore {
+			ties++
+			if s.rng.Intn(ties) == 0 {
+				best = m
+			}
+		}
+	}
+	return best
+}
+
+// lineScore оценивает ход по линиям длины WinLength через клетку m:
+// сколько своих символов он продолжает и сколько символов соперника перекрывает
+func lineScore(p *Position, m Move) int {
+	k := p.WinLength
+	directions := [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	score := 0
+	for _, d := range directions {
+		for offset := 0; offset < k; offset++ {
+			startX, startY := m.X-d[0]*offset, m.Y-d[1]*offset
+			if !p.Board.InBounds(startX, startY) || !p.Board.InBounds(startX+d[0]*(k-1), startY+d[1]*(k-1)) {
+				continue
+			}
+			mine, theirs := 0, 0
+			for step := 0; step < k; step++ {
+				switch p.Board[startX+d[0]*step][startY+d[1]*step] {
+				case "":
+				case p.Turn:
+					mine++
+				default:
+					theirs++
+				}
+			}
+			if theirs == 0 {
+				score += 2 * lineWeight(mine+1)
+			}
+			if mine == 0 {
+				score += lineWeight(theirs + 1)
+			}
+		}
+	}
+	return score
+}
+
+// immediateWin ищет среди moves ход, которым сторона side сразу выигрывает
+func immediateWin(rules Ruleset, p *Position, side string, moves []Move) (Move, bool) {
+	for _, m := range moves {
+		next := p.Clone()
+		next.Turn = side
+		if _, err := rules.Apply(next, m); err == nil && rules.Result(next).Winner == side {
+			return m, true
+		}
+	}
+	return Move{}, false
+}
+
+// playout доигрывает позицию случайными ходами среди кандидатов и возвращает победителя
+func (s *MCTS) playout(rules Ruleset, p *Position) string {
+	for {
+		result := rules.Result(p)
+		if result.Finished {
+			return result.Winner
+		}
+		moves := nearbyMoves(rules, p)
+		if len(moves) == 0 {
+			return ""
+		}
+		rules.Apply(p, s.playoutMove(p, moves))
+	}
+}
@@ -0,0 +1,36 @@
+package game
+
+import "testing"
+
+func TestMCTSSeededSearchIsDeterministic(t *testing.T) {
+	opts, err := Options{Variant: DefaultVariant, Size: 7, WinLength: 4}.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Два поиска с одним зерном и без ограничения по времени должны сыграть одну и ту же партию
+	play := func() []Move {
+		search := NewMCTS(500, 0, 42)
+		p := RulesFor(opts).NewPosition(opts)
+		for i := 0; i < 6; i++ {
+			m, _, ok := search.Search(&p)
+			if !ok {
+				break
+			}
+			if _, err := p.Rules().Apply(&p, m); err != nil {
+				t.Fatalf("illegal move %v: %v", m, err)
+			}
+		}
+		return p.History
+	}
+
+	first, second := play(), play()
+	if len(first) != len(second) {
+		t.Fatalf("played %d and %d moves", len(first), len(second))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("move %d differs: %v and %v", i, first[i], second[i])
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"tictactoe/db"
+)
+
+const (
+	// menaceMaxCells — MENACE хранит каждую позицию отдельно, поэтому играет только на маленьких досках
+	menaceMaxCells = 16
+	// Награды за партию: столько бусин добавляется к каждому сыгранному ходу
+	menaceWinReward  = 3
+	menaceDrawReward = 1
+	menaceLossReward = -1
+)
+
+var ErrBoardTooLarge = errors.New("Board is too large for this engine")
+
+// Learner — необязательный интерфейс движков, которые учатся на завершённых партиях
+type Learner interface {
+	Learn(gameID int) error
+}
+
+// Menace — обучаемый ИИ по образцу MENACE Мичи: для каждой позиции (с точностью до симметрии)
+// у каждого хода есть вес-«бусины», ход выбирается случайно пропорционально весу.
+// После партии веса сыгранных ходов растут при победе и ничьей и уменьшаются при поражении.
+// Веса хранятся в menace_weights, без БД Menace играет с начальными весами
+type Menace struct{}
+
+var menace = &Menace{}
+
+func init() {
+	RegisterEngine("menace", func() Engine { return menace })
+}
+
+func (*Menace) Name() string {
+	return "menace"
+}
+
+// Supports ограничивает MENACE досками не больше menaceMaxCells клеток. В ultimate доска 9×9
+func (*Menace) Supports(opts Options) bool {
+	n := RulesFor(opts).NewPosition(opts).Board.Size()
+	return n*n <= menaceMaxCells
+}
+
+func (m *Menace) BestMove(p *Position, side string) (Move, *Evaluation, error) {
+	pos := positionFor(p, side)
+	if !m.Supports(pos.Options) {
+		return Move{}, nil, ErrBoardTooLarge
+	}
+	rules := pos.Rules()
+	moves := rules.LegalMoves(pos)
+	if len(moves) == 0 {
+		return Move{}, nil, ErrNoMoves
+	}
+
+	key, t := optionsKey(rules, pos)
+	weights, err := menaceWeights(key)
+	if err != nil {
+		return Move{}, nil, err
+	}
+
+	n := pos.Board.Size()
+	total := 0
+	beads := make([]int, len(moves))
+	for i, move := range moves {
+		x, y := t(move.X, move.Y, n)
+		w, ok := weights[Move{X: x, Y: y}]
+		if !ok {
+			w = menaceInitialWeight(len(pos.History))
+		}
+		beads[i] = w
+		total += w
+	}
+	// Пустая «коробка» — все ходы из позиции проигрывали. MENACE сдавался, мы ходим наугад
+	if total == 0 {
+		return moves[rand.Intn(len(moves))], nil, nil
+	}
+	pick := rand.Intn(total)
+	for i, w := range beads {
+		if pick < w {
+			return moves[i], &Evaluation{Score: w}, nil
+		}
+		pick -= w
+	}
+	return moves[len(moves)-1], nil, nil
+}
+
+// Learn обновляет веса ходов ИИ в сохранённой оффлайн-партии. Каждая партия учитывается один раз
+func (m *Menace) Learn(gameID int) error {
+	opts, records, err := loadMoveRecords(gameID)
+	if err != nil {
+		return err
+	}
+	opts, err = opts.Normalize()
+	if err != nil {
+		return err
+	}
+	rules := RulesFor(opts)
+	p := rules.NewPosition(opts)
+	n := p.Board.Size()
+
+	type bead struct {
+		key  string
+		move Move
+		ply  int
+	}
+	var played []bead
+	aiSymbol := ""
+	for i, record := range records {
+		move := record.Move
+		if record.PlayerID == 0 {
+			aiSymbol = p.Turn
+			key, t := optionsKey(rules, &p)
+			x, y := t(move.X, move.Y, n)
+			played = append(played, bead{key: key, move: Move{X: x, Y: y}, ply: len(p.History)})
+		}
+		if _, err := rules.Apply(&p, move); err != nil {
+			return fmt.Errorf("game %d move %d: %v", gameID, i+1, err)
+		}
+	}
+	result := rules.Result(&p)
+	if !result.Finished || aiSymbol == "" {
+		return nil
+	}
+
+	outcome, reward := "draw", menaceDrawReward
+	if result.Winner == aiSymbol {
+		outcome, reward = "win", menaceWinReward
+	} else if result.Winner != "" {
+		outcome, reward = "loss", menaceLossReward
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"INSERT INTO menace_games (game_id, symbol, result, learned_at) VALUES ($1, $2, $3, $4) ON CONFLICT (game_id) DO NOTHING",
+		gameID, aiSymbol, outcome, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	if inserted, _ := res.RowsAffected(); inserted == 0 {
+		return nil
+	}
+	for _, b := range played {
+		initial := menaceInitialWeight(b.ply)
+		_, err := tx.Exec(
+			"INSERT INTO menace_weights (position_key, x, y, weight) VALUES ($1, $2, $3, GREATEST($4::int, 0)) "+
+				"ON CONFLICT (position_key, x, y) DO UPDATE SET weight = GREATEST(menace_weights.weight + $5, 0)",
+			b.key, b.move.X, b.move.Y, initial+reward, reward,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MenaceReport — сколько партий сыграл MENACE, с каким результатом и сколько позиций выучил
+type MenaceReport struct {
+	Games     int `json:"games"`
+	Wins      int `json:"wins"`
+	Draws     int `json:"draws"`
+	Losses    int `json:"losses"`
+	Positions int `json:"positions"`
+	// Recent — результаты последних партий, от старых к новым: "win", "draw" или "loss"
+	Recent []string `json:"recent"`
+}
+
+// MenaceStats возвращает прогресс обучения MENACE, recent — сколько последних партий вернуть
+func MenaceStats(recent int) (MenaceReport, error) {
+	var report MenaceReport
+	err := db.DB.QueryRow(
+		"SELECT COUNT(*), COUNT(*) FILTER (WHERE result = 'win'), COUNT(*) FILTER (WHERE result = 'draw'), COUNT(*) FILTER (WHERE result = 'loss') FROM menace_games",
+	).Scan(&report.Games, &report.Wins, &report.Draws, &report.Losses)
+	if err != nil {
+		return report, err
+	}
+	if err := db.DB.QueryRow("SELECT COUNT(DISTINCT position_key) FROM menace_weights").Scan(&report.Positions); err != nil {
+		return report, err
+	}
+
+	rows, err := db.DB.Query("SELECT result FROM menace_games ORDER BY learned_at DESC, game_id DESC LIMIT $1", recent)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	report.Recent = make([]string, 0, recent)
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return report, err
+		}
+		report.Recent = append([]string{result}, report.Recent...)
+	}
+	return report, rows.Err()
+}
+
+// optionsKey — ключ позиции вместе с параметрами партии: для menace_weights и дебютной книги
+func optionsKey(rules Ruleset, p *Position) (string, Transform) {
+	key, t := CanonicalKey(rules, p)
+	return fmt.Sprintf("%s:%d:%d:%v:%s", p.Variant, p.Size, p.WinLength, p.Misere, key), t
+}
+
+// menaceInitialWeight — начальное число бусин: как у Мичи, в ранних позициях больше
+func menaceInitialWeight(ply int) int {
+	if w := 4 - ply/2; w > 1 {
+		return w
+	}
+	return 1
+}
+
+func menaceWeights(key string) (map[Move]int, error) {
+	weights := make(map[Move]int)
+	if db.DB == nil {
+		return weights, nil
+	}
+	rows, err := db.DB.Query("SELECT x, y, weight FROM menace_weights WHERE position_key = $1", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m Move
+		var w int
+		if err := rows.Scan(&m.X, &m.Y, &w); err != nil {
+			return nil, err
+		}
+		weights[m] = w
+	}
+	return weights, rows.Err()
+}
@@ -0,0 +1,357 @@
+package game
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// WinScore — оценка выигранной позиции. Чем быстрее выигрыш, тем ближе оценка к WinScore
+	WinScore = 1000000
+	// winThreshold — оценки выше по модулю означают форсированный результат, а не эвристику
+	winThreshold = WinScore - 10000
+	// unlimitedDepth — глубина, которая используется, когда MaxDepth не задан
+	unlimitedDepth = 1 << 10
+	// maxFullWidth — при большем числе ходов перебираются только клетки рядом с уже занятыми
+	maxFullWidth = 30
+	// maxTableSize — при переполнении таблица транспозиций очищается
+	maxTableSize = 1 << 20
+	// deadlineCheckNodes — как часто поиск с Deadline проверяет время
+	deadlineCheckNodes = 1024
+)
+
+const (
+	boundExact = iota
+	boundLower
+	boundUpper
+)
+
+type ttEntry struct {
+	depth int
+	value int
+	bound int
+}
+
+// Minimax — поиск negamax с альфа-бета отсечением и таблицей транспозиций,
+// в которой симметричные позиции хранятся под одним ключом
+type Minimax struct {
+	MaxDepth int // 0 — перебор до конца партии
+	Nodes    int // число просмотренных позиций за последний поиск
+	// Deadline — после этого момента поиск прерывается (см. TimedOut), нулевое — без ограничения
+	Deadline time.Time
+
+	table    map[string]ttEntry
+	timedOut bool
+}
+
+func NewMinimax(maxDepth int) *Minimax {
+	return &Minimax{MaxDepth: maxDepth, table: make(map[string]ttEntry)}
+}
+
+// DefaultSearchDepth подбирает глубину поиска под размер доски: маленькие доски
+// перебираются полностью, на больших поиск ограничивается
+func DefaultSearchDepth(p *Position) int {
+	cells := p.Board.Size() * p.Board.Size()
+	switch {
+	case cells <= 9 && p.Variant != "rolling":
+		return 0
+	case cells <= 16:
+		return 6
+	case cells <= 49:
+		return 4
+	default:
+		return 3
+	}
+}
+
+// deepening возвращает глубины итеративного углубления до target. Полный перебор (0)
+// идёт одним проходом: он нужен только на маленьких досках
+func deepening(target int) []int {
+	if target == 0 {
+		return []int{0}
+	}
+	depths := make([]int, 0, target)
+	for depth := 1; depth <= target; depth++ {
+		depths = append(depths, depth)
+	}
+	return depths
+}
+
+// Search возвращает лучший ход для стороны p.Turn и его оценку с её точки зрения.
+// ok == false, если ходов нет или поиск прерван по Deadline
+func (s *Minimax) Search(p *Position) (Move, int, bool) {
+	rules := p.Rules()
+	s.reset()
+
+	moves := candidateMoves(rules, p)
+	if len(moves) == 0 {
+		return Move{}, 0, false
+	}
+
+	best, bestValue := moves[0], -WinScore-1
+	alpha, beta := -WinScore-1, WinScore+1
+	for _, m := range moves {
+		child := p.Clone()
+		if _, err := rules.Apply(child, m); err != nil {
+			continue
+		}
+		value := -s.negamax(rules, child, 1, -beta, -alpha)
+		if s.timedOut {
+			return Move{}, 0, false
+		}
+		if value > bestValue {
+			best, bestValue = m, value
+		}
+		if value > alpha {
+			alpha = value
+		}
+	}
+	return best, bestValue, true
+}
+
+// Evaluate возвращает оценку каждого допустимого хода для стороны p.Turn.
+// Если поиск прерван по Deadline, оценки неполные (см. TimedOut)
+func (s *Minimax) Evaluate(p *Position) map[Move]int {
+	rules := p.Rules()
+	s.reset()
+	values := make(map[Move]int)
+	for _, m := range candidateMoves(rules, p) {
+		child := p.Clone()
+		if _, err := rules.Apply(child, m); err != nil {
+			continue
+		}
+		value := -s.negamax(rules, child, 1, -WinScore-1, WinScore+1)
+		if s.timedOut {
+			break
+		}
+		values[m] = value
+	}
+	return values
+}
+
+// TimedOut сообщает, что последний поиск прерван по Deadline и его результат неполный
+func (s *Minimax) TimedOut() bool {
+	return s.timedOut
+}
+
+func (s *Minimax) reset() {
+	s.Nodes = 0
+	s.timedOut = false
+	if len(s.table) > maxTableSize {
+		s.table = make(map[string]ttEntry)
+	}
+}
+
+func (s *Minimax) negamax(rules Ruleset, p *Position, ply, alpha, beta int) int {
+	s.Nodes++
+	if !s.Deadline.IsZero() && s.Nodes%deadlineCheckNodes == 0 && time.Now().After(s.Deadline) {
+		s.timedOut = true
+	}
+	if s.timedOut {
+		return 0
+	}
+	if result := rules.Result(p); result.Finished {
+		switch result.Winner {
+		case "":
+			return 0
+		case p.Turn:
+			return WinScore - ply
+		default:
+			return -(WinScore - ply)
+		}
+	}
+
+	maxDepth := s.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = unlimitedDepth
+	}
+	if ply >= maxDepth {
+		return evaluatePosition(p)
+	}
+	remaining := maxDepth - ply
+
+	// В правилах, зависящих от истории (rolling), оценка зависит от повторений за всю партию
+	// и от числа сделанных ходов, а ключ позиции их не учитывает: таблицей там не пользуемся
+	historyDependent := historyWindow(rules) > 0
+	key := ""
+	if !historyDependent {
+		key, _ = CanonicalKey(rules, p)
+	}
+	alphaOrig := alpha
+	if entry, ok := s.table[key]; ok && !historyDependent && entry.depth >= remaining {
+		value := fromTable(entry.value, ply)
+		switch {
+		case entry.bound == boundExact:
+			return value
+		case entry.bound == boundLower && value > alpha:
+			alpha = value
+		case entry.bound == boundUpper && value < beta:
+			beta = value
+		}
+		if alpha >= beta {
+			return value
+		}
+	}
+
+	bestValue := -WinScore - 1
+	for _, m := range candidateMoves(rules, p) {
+		child := p.Clone()
+		if _, err := rules.Apply(child, m); err != nil {
+			continue
+		}
+		value := -s.negamax(rules, child, ply+1, -beta, -alpha)
+		if s.timedOut {
+			// Оценка недосчитана, в таблицу её записывать нельзя
+			return 0
+		}
+		if value > bestValue {
+			bestValue = value
+		}
+		if value > alpha {
+			alpha = value
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+
+	bound := boundExact
+	if bestValue <= alphaOrig {
+		bound = boundUpper
+	} else if bestValue >= beta {
+		bound = boundLower
+	}
+	if !historyDependent {
+		s.table[key] = ttEntry{depth: remaining, value: toTable(bestValue, ply), bound: bound}
+	}
+	return bestValue
+}
+
+// candidateMoves возвращает ходы в порядке перебора: сначала ближе к центру
+func candidateMoves(rules Ruleset, p *Position) []Move {
+	moves := nearbyMoves(rules, p)
+	n := p.Board.Size()
+	sort.SliceStable(moves, func(i, j int) bool {
+		return centerDistance(moves[i], n) < centerDistance(moves[j], n)
+	})
+	return moves
+}
+
+// nearbyMoves возвращает допустимые ходы. На больших досках оставляет только клетки
+// рядом с уже занятыми, а на пустой большой доске — ближайшую к центру
+func nearbyMoves(rules Ruleset, p *Position) []Move {
+	moves := rules.LegalMoves(p)
+	if len(moves) <= maxFullWidth {
+		return moves
+	}
+	n := p.Board.Size()
+	near := make([]Move, 0, len(moves))
+	center := moves[0]
+	for _, m := range moves {
+		if hasNeighbor(p.Board, m, 1) {
+			near = append(near, m)
+		}
+		if centerDistance(m, n) < centerDistance(center, n) {
+			center = m
+		}
+	}
+	if len(near) == 0 {
+		return []Move{center}
+	}
+	return near
+}
+
+func hasNeighbor(board Board, m Move, radius int) bool {
+	for dx := -radius; dx <= radius; dx++ {
+		for dy := -radius; dy <= radius; dy++ {
+			if (dx != 0 || dy != 0) && board.InBounds(m.X+dx, m.Y+dy) && board[m.X+dx][m.Y+dy] != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func centerDistance(m Move, n int) int {
+	dx, dy := 2*m.X-(n-1), 2*m.Y-(n-1)
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	return dx + dy
+}
+
+// evaluatePosition — эвристическая оценка для стороны p.Turn: каждая ещё возможная линия
+// длины WinLength приносит очки тому, чьи символы в ней стоят, тем больше, чем их больше.
+// В misère линии вредят своему владельцу, поэтому знак меняется
+func evaluatePosition(p *Position) int {
+	n, k := p.Board.Size(), p.WinLength
+	directions := [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	score := 0
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			for _, d := range directions {
+				endX, endY := i+d[0]*(k-1), j+d[1]*(k-1)
+				if !p.Board.InBounds(endX, endY) {
+					continue
+				}
+				mine, theirs := 0, 0
+				for step := 0; step < k; step++ {
+					switch p.Board[i+d[0]*step][j+d[1]*step] {
+					case "":
+					case p.Turn:
+						mine++
+					default:
+						theirs++
+					}
+				}
+				if mine > 0 && theirs == 0 {
+					score += lineWeight(mine)
+				} else if theirs > 0 && mine == 0 {
+					score -= lineWeight(theirs)
+				}
+			}
+		}
+	}
+	if p.Misere {
+		score = -score
+	}
+	if score > winThreshold/2 {
+		score = winThreshold / 2
+	} else if score < -winThreshold/2 {
+		score = -winThreshold / 2
+	}
+	return score
+}
+
+func lineWeight(count int) int {
+	weight := 1
+	for i := 1; i < count; i++ {
+		weight *= 10
+	}
+	return weight
+}
+
+// toTable и fromTable переводят оценки выигрыша в расстояние от текущей позиции,
+// чтобы запись в таблице не зависела от глубины, на которой позиция встретилась
+func toTable(value, ply int) int {
+	if value > winThreshold {
+		return value + ply
+	}
+	if value < -winThreshold {
+		return value - ply
+	}
+	return value
+}
+
+func fromTable(value, ply int) int {
+	if value > winThreshold {
+		return value - ply
+	}
+	if value < -winThreshold {
+		return value + ply
+	}
+	return value
+}
@@ -0,0 +1,63 @@
+package game
+
+import "testing"
+
+// assertNeverLoses перебирает все ответы соперника и проверяет, что Minimax без ограничения
+// глубины, играя символом side, не проигрывает ни одной партии
+func assertNeverLoses(t *testing.T, p *Position, side string) {
+	t.Helper()
+	rules := p.Rules()
+	if result := rules.Result(p); result.Finished {
+		if result.Winner != "" && result.Winner != side {
+			t.Fatalf("engine playing %s lost after %v", side, p.History)
+		}
+		return
+	}
+
+	if p.Turn == side {
+		m, _, ok := NewMinimax(0).Search(p)
+		if !ok {
+			t.Fatalf("no move for %s after %v", side, p.History)
+		}
+		child := p.Clone()
+		if _, err := rules.Apply(child, m); err != nil {
+			t.Fatalf("illegal move %v after %v: %v", m, p.History, err)
+		}
+		assertNeverLoses(t, child, side)
+		return
+	}
+	for _, m := range rules.LegalMoves(p) {
+		child := p.Clone()
+		if _, err := rules.Apply(child, m); err != nil {
+			t.Fatalf("illegal move %v after %v: %v", m, p.History, err)
+		}
+		assertNeverLoses(t, child, side)
+	}
+}
+
+func TestMinimaxNeverLoses3x3(t *testing.T) {
+	for _, misere := range []bool{false, true} {
+		opts, err := Options{Variant: DefaultVariant, Size: 3, Misere: misere}.Normalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, side := range []string{"X", "O"} {
+			p := RulesFor(opts).NewPosition(opts)
+			assertNeverLoses(t, &p, side)
+		}
+	}
+}
+
+func TestMinimaxMisereUsesTable(t *testing.T) {
+	// misere реализует HistoryDependent и для правил без истории: таблица должна работать
+	opts, err := Options{Variant: DefaultVariant, Size: 4, WinLength: 3, Misere: true}.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := RulesFor(opts).NewPosition(opts)
+	search := NewMinimax(4)
+	search.Search(&p)
+	if len(search.table) == 0 {
+		t.Fatal("misère search stored nothing in the transposition table")
+	}
+}
@@ -0,0 +1,14 @@
+package game
+
+// misereRules — обратные крестики-нолики поверх любого варианта: тот, кто собрал линию, проигрывает
+type misereRules struct {
+	Ruleset
+}
+
+func (r misereRules) Result(p *Position) Result {
+	result := r.Ruleset.Result(p)
+	if result.Winner != "" {
+		result.Winner = nextTurn(result.Winner)
+	}
+	return result
+}
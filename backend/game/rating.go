@@ -0,0 +1,178 @@
+package game
+
+import (
+	"database/sql"
+	"math"
+	"time"
+
+	"tictactoe/db"
+)
+
+// Параметры Glicko-2: начальный рейтинг, отклонение и волатильность, ограничение
+// на изменение волатильности (tau) и масштаб перевода в шкалу Glicko-2
+const (
+	DefaultRating     = 1500.0
+	DefaultDeviation  = 350.0
+	DefaultVolatility = 0.06
+	glickoTau         = 0.5
+	glickoScale       = 173.7178
+	glickoEpsilon     = 0.000001
+)
+
+// Rating — рейтинг игрока по Glicko-2. Deviation показывает, насколько рейтинг ещё неточен
+type Rating struct {
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"-"`
+	Games      int     `json:"games"`
+}
+
+func DefaultPlayerRating() Rating {
+	return Rating{Rating: DefaultRating, Deviation: DefaultDeviation, Volatility: DefaultVolatility}
+}
+
+// Update возвращает рейтинг после одной партии против opponent. score — 1 за победу,
+// 0.5 за ничью, 0 за поражение. Каждая партия считается отдельным рейтинговым периодом
+func (r Rating) Update(opponent Rating, score float64) Rating {
+	mu, phi := (r.Rating-DefaultRating)/glickoScale, r.Deviation/glickoScale
+	muJ, phiJ := (opponent.Rating-DefaultRating)/glickoScale, opponent.Deviation/glickoScale
+
+	g := 1 / math.Sqrt(1+3*phiJ*phiJ/(math.Pi*math.Pi))
+	expected := 1 / (1 + math.Exp(-g*(mu-muJ)))
+	v := 1 / (g * g * expected * (1 - expected))
+	delta := v * g * (score - expected)
+
+	sigma := newVolatility(phi, v, delta, r.Volatility)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	newPhi := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	newMu := mu + newPhi*newPhi*g*(score-expected)
+
+	return Rating{
+		Rating:     glickoScale*newMu + DefaultRating,
+		Deviation:  glickoScale * newPhi,
+		Volatility: sigma,
+		Games:      r.Games + 1,
+	}
+}
+
+// newVolatility решает уравнение на волатильность методом Иллинойса (шаг 5 алгоритма Glicko-2)
+func newVolatility(phi, v, delta, sigma float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
+
+// LoadRating возвращает рейтинг игрока или начальный, если он ещё не играл онлайн
+func LoadRating(playerID int) (Rating, error) {
+	r := DefaultPlayerRating()
+	err := db.DB.QueryRow(
+		"SELECT rating, deviation, volatility, games FROM ratings WHERE player_id = $1", playerID,
+	).Scan(&r.Rating, &r.Deviation, &r.Volatility, &r.Games)
+	if err == sql.ErrNoRows {
+		return DefaultPlayerRating(), nil
+	}
+	return r, err
+}
+
+// UpdateRatings пересчитывает рейтинги обоих игроков завершённой онлайн-партии в одной транзакции
+// и записывает изменения в rating_history. winner — символ победителя или пустая строка при ничьей
+func UpdateRatings(game *Game, winner string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, playerID := range []int{game.Player1ID, game.Player2ID} {
+		_, err := tx.Exec(
+			"INSERT INTO ratings (player_id, rating, deviation, volatility, games) VALUES ($1, $2, $3, $4, 0) ON CONFLICT (player_id) DO NOTHING",
+			playerID, DefaultRating, DefaultDeviation, DefaultVolatility,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Блокируем строки в порядке player_id, чтобы параллельные партии не взаимоблокировались
+	rows, err := tx.Query(
+		"SELECT player_id, rating, deviation, volatility, games FROM ratings WHERE player_id IN ($1, $2) ORDER BY player_id FOR UPDATE",
+		game.Player1ID, game.Player2ID,
+	)
+	if err != nil {
+		return err
+	}
+	ratings := make(map[int]Rating)
+	for rows.Next() {
+		var playerID int
+		var r Rating
+		if err := rows.Scan(&playerID, &r.Rating, &r.Deviation, &r.Volatility, &r.Games); err != nil {
+			rows.Close()
+			return err
+		}
+		ratings[playerID] = r
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	score1 := 0.5
+	switch winner {
+	case game.PlayerSymbol(game.Player1ID):
+		score1 = 1
+	case game.PlayerSymbol(game.Player2ID):
+		score1 = 0
+	}
+	before1, before2 := ratings[game.Player1ID], ratings[game.Player2ID]
+	updated := map[int]Rating{
+		game.Player1ID: before1.Update(before2, score1),
+		game.Player2ID: before2.Update(before1, 1-score1),
+	}
+
+	now := time.Now()
+	for playerID, r := range updated {
+		_, err := tx.Exec(
+			"UPDATE ratings SET rating=$1, deviation=$2, volatility=$3, games=$4, updated_at=$5 WHERE player_id=$6",
+			r.Rating, r.Deviation, r.Volatility, r.Games, now, playerID,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"INSERT INTO rating_history (game_id, player_id, rating_before, rating_after, deviation_after, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+			game.ID, playerID, ratings[playerID].Rating, r.Rating, r.Deviation, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,50 @@
+package game
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRatingUpdate(t *testing.T) {
+	player, opponent := DefaultPlayerRating(), DefaultPlayerRating()
+
+	win := player.Update(opponent, 1)
+	if win.Rating <= player.Rating {
+		t.Errorf("rating after a win = %.1f, want above %.1f", win.Rating, player.Rating)
+	}
+	loss := player.Update(opponent, 0)
+	if loss.Rating >= player.Rating {
+		t.Errorf("rating after a loss = %.1f, want below %.1f", loss.Rating, player.Rating)
+	}
+	// Между равными соперниками победа и поражение симметричны
+	if math.Abs((win.Rating-player.Rating)-(player.Rating-loss.Rating)) > 1e-6 {
+		t.Errorf("win gains %.3f, loss costs %.3f", win.Rating-player.Rating, player.Rating-loss.Rating)
+	}
+
+	draw := player.Update(opponent, 0.5)
+	if math.Abs(draw.Rating-player.Rating) > 1e-6 {
+		t.Errorf("rating after a draw between equals = %.3f, want %.3f", draw.Rating, player.Rating)
+	}
+
+	for _, r := range []Rating{win, loss, draw} {
+		if r.Deviation >= player.Deviation {
+			t.Errorf("deviation = %.1f, want below %.1f after a game", r.Deviation, player.Deviation)
+		}
+		if r.Games != player.Games+1 {
+			t.Errorf("games = %d, want %d", r.Games, player.Games+1)
+		}
+	}
+}
+
+func TestRatingUpdateFavouriteGainsLess(t *testing.T) {
+	// Победа над слабым соперником приносит меньше, чем над сильным
+	player := Rating{Rating: 1500, Deviation: 100, Volatility: DefaultVolatility}
+	weak := Rating{Rating: 1300, Deviation: 100, Volatility: DefaultVolatility}
+	strong := Rating{Rating: 1700, Deviation: 100, Volatility: DefaultVolatility}
+
+	gainWeak := player.Update(weak, 1).Rating - player.Rating
+	gainStrong := player.Update(strong, 1).Rating - player.Rating
+	if gainWeak >= gainStrong {
+		t.Errorf("win over 1300 gains %.1f, over 1700 %.1f, want less", gainWeak, gainStrong)
+	}
+}
@@ -0,0 +1,64 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"tictactoe/db"
+)
+
+// Replay проигрывает ходы с начальной позиции варианта и возвращает итоговую позицию
+func Replay(opts Options, moves []Move) (*Position, error) {
+	opts, err := opts.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	rules := RulesFor(opts)
+	p := rules.NewPosition(opts)
+	for i, m := range moves {
+		if _, err := rules.Apply(&p, m); err != nil {
+			return &p, fmt.Errorf("move %d [%d,%d]: %v", i+1, m.X, m.Y, err)
+		}
+	}
+	return &p, nil
+}
+
+// LoadGameMoves читает параметры партии из games.board и её ходы из таблицы moves в порядке их записи
+func LoadGameMoves(gameID int) (Options, []Move, error) {
+	var boardJSON []byte
+	if err := db.DB.QueryRow("SELECT board FROM games WHERE id = $1", gameID).Scan(&boardJSON); err != nil {
+		return Options{}, nil, err
+	}
+	// Партии, сохранённые до появления размеров доски, хранят только клетки 3×3
+	opts := DefaultOptions()
+	var record boardRecord
+	if err := json.Unmarshal(boardJSON, &record); err == nil {
+		opts = Options{Variant: record.Variant, Size: record.Size, WinLength: record.WinLength, Misere: record.Misere}
+	}
+
+	rows, err := db.DB.Query("SELECT x, y FROM moves WHERE game_id = $1 ORDER BY id", gameID)
+	if err != nil {
+		return opts, nil, err
+	}
+	defer rows.Close()
+
+	moves := make([]Move, 0)
+	for rows.Next() {
+		var m Move
+		if err := rows.Scan(&m.X, &m.Y); err != nil {
+			return opts, nil, err
+		}
+		moves = append(moves, m)
+	}
+	return opts, moves, rows.Err()
+}
+
+// ReplayGame восстанавливает позицию сохранённой партии по таблице moves
+func ReplayGame(gameID int) (*Position, []Move, error) {
+	opts, moves, err := LoadGameMoves(gameID)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := Replay(opts, moves)
+	return p, moves, err
+}
@@ -0,0 +1,112 @@
+package game
+
+import "errors"
+
+const (
+	// RollingMarks — сколько символов одного игрока может стоять на доске одновременно
+	RollingMarks = 3
+	// RollingMoveLimit — после стольких ходов партия без победителя считается ничьей
+	RollingMoveLimit = 100
+	// RollingRepetitions — ничья, если одна и та же позиция повторилась столько раз
+	RollingRepetitions = 3
+)
+
+// rollingRules — у каждого игрока на доске не больше трёх символов: четвёртый ход
+// снимает самый старый символ этого игрока, поэтому доска никогда не заполняется.
+// Бесконечные партии заканчиваются ничьей по троекратному повторению или лимиту ходов
+type rollingRules struct{}
+
+func init() {
+	RegisterRuleset(rollingRules{})
+}
+
+func (rollingRules) Name() string {
+	return "rolling"
+}
+
+func (rollingRules) Normalize(opts Options) (Options, error) {
+	if opts.Size == 0 {
+		opts.Size = DefaultSize
+	}
+	if opts.WinLength != 0 && opts.WinLength != RollingMarks {
+		return opts, errors.New("rolling is played with 3 in a row")
+	}
+	opts.WinLength = RollingMarks
+	return opts, opts.Validate()
+}
+
+func (rollingRules) NewPosition(opts Options) Position {
+	return Position{Options: opts, Board: NewBoard(opts.Size), Turn: "X", ActiveBoard: AnyBoard}
+}
+
+func (r rollingRules) LegalMoves(p *Position) []Move {
+	if r.Result(p).Finished {
+		return nil
+	}
+	moves := make([]Move, 0)
+	for i := range p.Board {
+		for j := range p.Board[i] {
+			if p.Board[i][j] == "" {
+				moves = append(moves, Move{X: i, Y: j})
+			}
+		}
+	}
+	return moves
+}
+
+func (r rollingRules) Apply(p *Position, m Move) (Move, error) {
+	if r.Result(p).Finished {
+		return m, ErrGameFinished
+	}
+	if !p.Board.InBounds(m.X, m.Y) {
+		return m, ErrInvalidCoordinates
+	}
+	if p.Board[m.X][m.Y] != "" {
+		return m, ErrCellOccupied
+	}
+
+	var removed *Move
+	if own := p.PlayerMoves(p.Turn); len(own) >= RollingMarks {
+		oldest := own[len(own)-RollingMarks]
+		p.Board[oldest.X][oldest.Y] = ""
+		removed = &oldest
+	}
+	p.place(m)
+	p.Removed = removed
+	return m, nil
+}
+
+func (rollingRules) Result(p *Position) Result {
+	if winner := p.Board.CheckWinner(p.WinLength); winner != "" {
+		return Result{Finished: true, Winner: winner}
+	}
+	if len(p.History) >= RollingMoveLimit || rollingRepetitions(p.History) >= RollingRepetitions {
+		return Result{Finished: true}
+	}
+	return Result{}
+}
+
+// rollingRepetitions считает, сколько раз встречалась текущая позиция. Позиция после хода
+// полностью задаётся последними 2*RollingMarks ходами, включая порядок снятия символов
+func rollingRepetitions(history []Move) int {
+	window := 2 * RollingMarks
+	last := len(history)
+	if last < window {
+		return 1
+	}
+	count := 0
+	// Сравниваем только позиции с той же стороной на ходу
+	for end := last; end >= window; end -= 2 {
+		same := true
+		for i := 1; i <= window; i++ {
+			if history[end-i] != history[last-i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,69 @@
+package game
+
+import "testing"
+
+// rollingCycle — ходы, после которых позиция в rolling повторяется каждые 8 ходов, начиная с 6-го,
+// и ни у кого не складывается линия
+var rollingCycle = []Move{
+	{X: 0, Y: 0}, {X: 1, Y: 0},
+	{X: 0, Y: 1}, {X: 2, Y: 0},
+	{X: 1, Y: 2}, {X: 2, Y: 1},
+	{X: 2, Y: 2}, {X: 0, Y: 2},
+}
+
+func newRollingPosition(t *testing.T, moves int) *Position {
+	t.Helper()
+	opts, err := Options{Variant: "rolling", Size: 3}.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := RulesFor(opts).NewPosition(opts)
+	for i := 0; i < moves; i++ {
+		if _, err := p.Rules().Apply(&p, rollingCycle[i%len(rollingCycle)]); err != nil {
+			t.Fatalf("move %d: %v", i, err)
+		}
+	}
+	return &p
+}
+
+func TestRollingThreefoldRepetitionIsDraw(t *testing.T) {
+	// Позиция после 6-го хода повторяется после 14-го и 22-го
+	const third = 22
+	for moves := 0; moves < third; moves++ {
+		p := newRollingPosition(t, moves)
+		if p.Rules().Result(p).Finished {
+			t.Fatalf("game finished after %d moves, want it to go on until %d", moves, third)
+		}
+	}
+	p := newRollingPosition(t, third)
+	if got := rollingRepetitions(p.History); got != RollingRepetitions {
+		t.Fatalf("repetitions = %d, want %d", got, RollingRepetitions)
+	}
+	if result := p.Rules().Result(p); !result.Finished || result.Winner != "" {
+		t.Fatalf("result = %+v, want a draw", result)
+	}
+}
+
+func TestMinimaxRollingDependsOnMoveCount(t *testing.T) {
+	// Доска и последние ходы одинаковые, но вторая позиция в шести ходах от RollingMoveLimit:
+	// поиск, начатый с первой, не должен переносить её оценки на вторую
+	first := newRollingPosition(t, 6)
+	late := first.Clone()
+	played := RollingMoveLimit - 6
+	late.History = make([]Move, 0, played+len(first.History))
+	for i := 0; i < played-len(first.History); i++ {
+		// Эти ходы задают только длину партии и не совпадают ни с одним ходом на доске
+		late.History = append(late.History, Move{X: -1, Y: i})
+	}
+	late.History = append(late.History, first.History...)
+
+	shared := NewMinimax(8)
+	shared.Evaluate(first)
+	got := shared.Evaluate(late)
+	want := NewMinimax(8).Evaluate(late)
+	for m, v := range want {
+		if got[m] != v {
+			t.Errorf("move %v: value %d after searching another position, want %d", m, got[m], v)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package game
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRoomTTL — сколько комната ждёт второго игрока
+	DefaultRoomTTL = 15 * time.Minute
+	// MaxRoomAttempts — после стольких неверных паролей комната закрывается
+	MaxRoomAttempts = 5
+	// roomCodeLength и roomCodeAlphabet — коды без похожих символов (0/O, 1/I), их легко продиктовать
+	roomCodeLength   = 6
+	roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	ErrRoomNotFound  = errors.New("Room not found or expired")
+	ErrWrongPassword = errors.New("Wrong room password")
+	ErrOwnRoom       = errors.New("You cannot join your own room")
+	ErrHostLeft      = errors.New("The room host has left")
+)
+
+// Room — приватная комната: хозяин ждёт, пока по коду не подключится второй игрок
+type Room struct {
+	Code      string
+	HostID    int
+	Options   Options
+	ExpiresAt time.Time
+
+	salt         []byte
+	passwordHash []byte // пустой — комната без пароля
+	attempts     int    // сколько раз ввели неверный пароль
+}
+
+// CreateRoom создаёт комнату для hostID. Пустой password — вход только по коду
+func (gm *GameManager) CreateRoom(hostID int, opts Options, password string) (*Room, error) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	code, err := gm.newRoomCode()
+	if err != nil {
+		return nil, err
+	}
+	room := &Room{Code: code, HostID: hostID, Options: opts, ExpiresAt: time.Now().Add(gm.RoomTTL)}
+	if password != "" {
+		room.salt = make([]byte, 16)
+		if _, err := rand.Read(room.salt); err != nil {
+			return nil, err
+		}
+		room.passwordHash = hashRoomPassword(room.salt, password)
+	}
+	gm.rooms[code] = room
+	log.Printf("Player %d created room %s (%s %dx%d, password: %v)", hostID, code, opts.Variant, opts.Size, opts.Size, password != "")
+	return room, nil
+}
+
+// ClaimRoom проверяет код и пароль и забирает комнату из списка открытых, чтобы второй
+// игрок мог занять её, не создавая пользователя на каждую неудачную попытку. Дальше комнату
+// нужно передать в StartRoomGame или вернуть через ReleaseRoom. После MaxRoomAttempts
+// неверных паролей комната закрывается
+func (gm *GameManager) ClaimRoom(code, password string) (*Room, error) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	room, ok := gm.rooms[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok || time.Now().After(room.ExpiresAt) {
+		return nil, ErrRoomNotFound
+	}
+	if room.passwordHash != nil && subtle.ConstantTimeCompare(room.passwordHash, hashRoomPassword(room.salt, password)) != 1 {
+		room.attempts++
+		log.Printf("Wrong password for room %s, attempt %d", room.Code, room.attempts)
+		if room.attempts >= MaxRoomAttempts {
+			gm.closeRoom(room, "Too many wrong passwords")
+		}
+		return nil, ErrWrongPassword
+	}
+	delete(gm.rooms, room.Code)
+	return room, nil
+}
+
+// ReleaseRoom возвращает забранную комнату, если второй игрок так и не сел за стол.
+// Если хозяин за это время отключился, комната закрывается
+func (gm *GameManager) ReleaseRoom(room *Room) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	gm.releaseRoom(room)
+}
+
+// releaseRoom — ReleaseRoom под gm.mu
+func (gm *GameManager) releaseRoom(room *Room) {
+	if _, ok := gm.clients[room.HostID]; !ok {
+		log.Printf("Room %s closed: host %d disconnected", room.Code, room.HostID)
+		return
+	}
+	if _, taken := gm.rooms[room.Code]; !taken && time.Now().Before(room.ExpiresAt) {
+		gm.rooms[room.Code] = room
+	}
+}
+
+// StartRoomGame сажает игрока за стол хозяина забранной комнаты и начинает обычную партию:
+// хозяин играет X. Если хозяин уже отключился, комната закрывается с ErrHostLeft
+func (gm *GameManager) StartRoomGame(room *Room, playerID int) (*Game, error) {
+	if room.HostID == playerID {
+		gm.ReleaseRoom(room)
+		return nil, ErrOwnRoom
+	}
+
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	if _, ok := gm.clients[room.HostID]; !ok {
+		log.Printf("Room %s closed: host %d disconnected before player %d joined", room.Code, room.HostID, playerID)
+		return nil, ErrHostLeft
+	}
+	game, err := gm.createGame(NewGame(0, room.HostID, playerID, room.Options))
+	if err != nil {
+		gm.releaseRoom(room)
+		return nil, err
+	}
+	log.Printf("Player %d joined room %s, game %d", playerID, room.Code, game.ID)
+
+	go func() {
+		time.Sleep(1000 * time.Millisecond)
+		gm.NotifyPlayers(game)
+	}()
+	return game, nil
+}
+
+// expireRooms закрывает комнаты, в которые никто не пришёл. Вызывается под gm.mu
+func (gm *GameManager) expireRooms(now time.Time) {
+	for _, room := range gm.rooms {
+		if now.After(room.ExpiresAt) {
+			gm.closeRoom(room, "Room expired")
+		}
+	}
+}
+
+// closeRoom удаляет комнату и сообщает об этом хозяину. Вызывается под gm.mu
+func (gm *GameManager) closeRoom(room *Room, reason string) {
+	delete(gm.rooms, room.Code)
+	log.Printf("Room %s of player %d closed: %s", room.Code, room.HostID, reason)
+	if client, ok := gm.clients[room.HostID]; ok {
+		client.Conn.WriteJSON(map[string]interface{}{
+			"type":    "room_closed",
+			"code":    room.Code,
+			"message": reason,
+		})
+	}
+}
+
+// removeHostedRooms закрывает комнаты игрока, который отключился. Вызывается под gm.mu
+func (gm *GameManager) removeHostedRooms(playerID int) {
+	for code, room := range gm.rooms {
+		if room.HostID == playerID {
+			delete(gm.rooms, code)
+			log.Printf("Room %s closed: host %d disconnected", code, playerID)
+		}
+	}
+}
+
+// newRoomCode выдаёт код, которого нет среди открытых комнат. Вызывается под gm.mu
+func (gm *GameManager) newRoomCode() (string, error) {
+	for {
+		var sb strings.Builder
+		for i := 0; i < roomCodeLength; i++ {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(roomCodeAlphabet))))
+			if err != nil {
+				return "", err
+			}
+			sb.WriteByte(roomCodeAlphabet[n.Int64()])
+		}
+		if _, taken := gm.rooms[sb.String()]; !taken {
+			return sb.String(), nil
+		}
+	}
+}
+
+func hashRoomPassword(salt []byte, password string) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), password...))
+	return sum[:]
+}
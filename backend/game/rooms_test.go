@@ -0,0 +1,143 @@
+package game
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateRoomCode(t *testing.T) {
+	gm := NewGameManager()
+	for i := 0; i < 100; i++ {
+		room, err := gm.CreateRoom(1, DefaultOptions(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(room.Code) != roomCodeLength {
+			t.Fatalf("code %q has length %d, want %d", room.Code, len(room.Code), roomCodeLength)
+		}
+		for _, c := range room.Code {
+			if !strings.ContainsRune(roomCodeAlphabet, c) {
+				t.Fatalf("code %q contains %q outside the alphabet", room.Code, c)
+			}
+		}
+	}
+	if len(gm.rooms) != 100 {
+		t.Fatalf("%d open rooms, want 100 with distinct codes", len(gm.rooms))
+	}
+}
+
+func TestClaimRoom(t *testing.T) {
+	gm := NewGameManager()
+	gm.clients[1] = &Client{PlayerID: 1}
+	room, err := gm.CreateRoom(1, DefaultOptions(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gm.ClaimRoom(room.Code, "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("wrong password: err = %v, want %v", err, ErrWrongPassword)
+	}
+	// Код можно ввести в любом регистре и с пробелами
+	claimed, err := gm.ClaimRoom(" "+strings.ToLower(room.Code)+" ", "secret")
+	if err != nil || claimed != room {
+		t.Fatalf("claim = %v, %v, want the room", claimed, err)
+	}
+	if _, err := gm.ClaimRoom(room.Code, "secret"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("second claim: err = %v, want %v", err, ErrRoomNotFound)
+	}
+
+	gm.ReleaseRoom(claimed)
+	if _, err := gm.ClaimRoom(room.Code, "secret"); err != nil {
+		t.Fatalf("claim after release: %v", err)
+	}
+}
+
+func TestClaimRoomAttemptLimit(t *testing.T) {
+	gm := NewGameManager()
+	room, err := gm.CreateRoom(1, DefaultOptions(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < MaxRoomAttempts; i++ {
+		if _, err := gm.ClaimRoom(room.Code, "guess"); !errors.Is(err, ErrWrongPassword) {
+			t.Fatalf("attempt %d: err = %v, want %v", i+1, err, ErrWrongPassword)
+		}
+	}
+	if _, err := gm.ClaimRoom(room.Code, "secret"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("after %d wrong passwords: err = %v, want the room closed", MaxRoomAttempts, err)
+	}
+}
+
+func TestRoomExpiry(t *testing.T) {
+	gm := NewGameManager()
+	gm.RoomTTL = time.Minute
+	room, err := gm.CreateRoom(1, DefaultOptions(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := gm.ClaimRoom(room.Code, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Просроченную комнату нельзя вернуть
+	claimed.ExpiresAt = time.Now().Add(-time.Second)
+	gm.ReleaseRoom(claimed)
+	if _, err := gm.ClaimRoom(room.Code, ""); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("claim of an expired room: err = %v, want %v", err, ErrRoomNotFound)
+	}
+
+	other, err := gm.CreateRoom(2, DefaultOptions(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm.expireRooms(time.Now().Add(2 * time.Minute))
+	if _, ok := gm.rooms[other.Code]; ok {
+		t.Fatalf("room %s is still open after it expired", other.Code)
+	}
+}
+
+func TestStartRoomGameOwnRoom(t *testing.T) {
+	gm := NewGameManager()
+	gm.clients[1] = &Client{PlayerID: 1}
+	room, err := gm.CreateRoom(1, DefaultOptions(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := gm.ClaimRoom(room.Code, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gm.StartRoomGame(claimed, 1); !errors.Is(err, ErrOwnRoom) {
+		t.Fatalf("err = %v, want %v", err, ErrOwnRoom)
+	}
+	if _, ok := gm.rooms[room.Code]; !ok {
+		t.Fatal("room was not returned after the host tried to join it")
+	}
+}
+
+func TestRoomHostLeft(t *testing.T) {
+	gm := NewGameManager()
+	room, err := gm.CreateRoom(1, DefaultOptions(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := gm.ClaimRoom(room.Code, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Хозяин так и не подключился или уже ушёл: партия не начинается, комната не возвращается
+	if _, err := gm.StartRoomGame(claimed, 2); !errors.Is(err, ErrHostLeft) {
+		t.Fatalf("err = %v, want %v", err, ErrHostLeft)
+	}
+	gm.ReleaseRoom(claimed)
+	if _, ok := gm.rooms[room.Code]; ok {
+		t.Fatal("room was reopened after its host left")
+	}
+	if len(gm.games) != 0 {
+		t.Fatalf("%d games started without the host", len(gm.games))
+	}
+}
@@ -0,0 +1,136 @@
+package game
+
+import (
+	"errors"
+	"sort"
+)
+
+const DefaultVariant = "classic"
+
+var (
+	ErrInvalidCoordinates = errors.New("Invalid coordinates")
+	ErrCellOccupied       = errors.New("Cell already occupied")
+	ErrGameFinished       = errors.New("Game is already finished")
+)
+
+type Move struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Position — состояние партии, с которым работают правила: доска, чей ход и параметры варианта
+type Position struct {
+	Options
+	Board       Board
+	Turn        string // "X" или "O"
+	ActiveBoard int    // малая доска, куда обязан ходить игрок в ultimate, или AnyBoard
+	History     []Move // все ходы партии по порядку: чётные — X, нечётные — O
+	Removed     *Move  // символ, снятый с доски последним ходом (вариант rolling)
+}
+
+// Result — итог позиции. Winner пустой, если партия не закончена или закончилась ничьей
+type Result struct {
+	Finished bool
+	Winner   string
+}
+
+// Ruleset описывает вариант игры: допустимые ходы, порядок ходов и определение результата
+type Ruleset interface {
+	Name() string
+	// Normalize проверяет параметры партии и подставляет значения по умолчанию для варианта
+	Normalize(opts Options) (Options, error)
+	NewPosition(opts Options) Position
+	LegalMoves(p *Position) []Move
+	// Apply делает ход за p.Turn, передаёт ход сопернику и возвращает фактически сделанный ход
+	Apply(p *Position, m Move) (Move, error)
+	Result(p *Position) Result
+}
+
+var rulesets = make(map[string]Ruleset)
+
+func RegisterRuleset(r Ruleset) {
+	rulesets[r.Name()] = r
+}
+
+func GetRuleset(name string) (Ruleset, bool) {
+	if name == "" {
+		name = DefaultVariant
+	}
+	r, ok := rulesets[name]
+	return r, ok
+}
+
+// Variants возвращает имена всех зарегистрированных вариантов
+func Variants() []string {
+	names := make([]string, 0, len(rulesets))
+	for name := range rulesets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Normalize проверяет параметры через правила выбранного варианта
+func (o Options) Normalize() (Options, error) {
+	if o.Variant == "" {
+		o.Variant = DefaultVariant
+	}
+	rules, ok := GetRuleset(o.Variant)
+	if !ok {
+		return o, errors.New("unknown variant: " + o.Variant)
+	}
+	return rules.Normalize(o)
+}
+
+// RulesFor возвращает правила для параметров партии: вариант по умолчанию — классический,
+// в режиме misère результат переворачивается
+func RulesFor(opts Options) Ruleset {
+	rules, ok := GetRuleset(opts.Variant)
+	if !ok {
+		rules, _ = GetRuleset(DefaultVariant)
+	}
+	if opts.Misere {
+		return misereRules{Ruleset: rules}
+	}
+	return rules
+}
+
+// Rules возвращает правила, по которым играется позиция
+func (p *Position) Rules() Ruleset {
+	return RulesFor(p.Options)
+}
+
+func (p *Position) Clone() *Position {
+	c := *p
+	c.Board = p.Board.Clone()
+	c.History = append([]Move(nil), p.History...)
+	return &c
+}
+
+// PlayerMoves возвращает ходы одного игрока в порядке, в котором они сделаны
+func (p *Position) PlayerMoves(symbol string) []Move {
+	start := 0
+	if symbol == "O" {
+		start = 1
+	}
+	moves := make([]Move, 0, len(p.History)/2+1)
+	for i := start; i < len(p.History); i += 2 {
+		moves = append(moves, p.History[i])
+	}
+	return moves
+}
+
+// place ставит символ стороны, чей ход, записывает ход в историю и передаёт ход сопернику
+func (p *Position) place(m Move) {
+	p.Board[m.X][m.Y] = p.Turn
+	p.History = append(p.History, m)
+	p.Removed = nil
+	p.Turn = nextTurn(p.Turn)
+}
+
+func nextTurn(turn string) string {
+	if turn == "X" {
+		return "O"
+	}
+	return "X"
+}
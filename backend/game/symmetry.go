@@ -0,0 +1,129 @@
+package game
+
+import "strings"
+
+// Transform — поворот или отражение клетки доски размера n
+type Transform func(x, y, n int) (int, int)
+
+var (
+	identity      Transform = func(x, y, n int) (int, int) { return x, y }
+	rotate90      Transform = func(x, y, n int) (int, int) { return y, n - 1 - x }
+	rotate180     Transform = func(x, y, n int) (int, int) { return n - 1 - x, n - 1 - y }
+	rotate270     Transform = func(x, y, n int) (int, int) { return n - 1 - y, x }
+	mirrorRows    Transform = func(x, y, n int) (int, int) { return n - 1 - x, y }
+	mirrorColumns Transform = func(x, y, n int) (int, int) { return x, n - 1 - y }
+	transpose     Transform = func(x, y, n int) (int, int) { return y, x }
+	antiTranspose Transform = func(x, y, n int) (int, int) { return n - 1 - y, n - 1 - x }
+
+	// allTransforms — все 8 симметрий квадрата, первой идёт тождественная
+	allTransforms = []Transform{identity, rotate90, rotate180, rotate270, mirrorRows, mirrorColumns, transpose, antiTranspose}
+)
+
+// Symmetric — необязательный интерфейс правил, которые не меняются при части
+// преобразований доски. Без него позиция считается несимметричной
+type Symmetric interface {
+	Symmetries() []Transform
+}
+
+// HistoryDependent — необязательный интерфейс правил, в которых позиция задаётся
+// не только доской, но и порядком последних HistoryWindow() ходов
+type HistoryDependent interface {
+	HistoryWindow() int
+}
+
+func (classicRules) Symmetries() []Transform {
+	return allTransforms
+}
+
+// В ultimate симметрии доски 9×9 переводят малые доски в малые доски
+func (ultimateRules) Symmetries() []Transform {
+	return allTransforms
+}
+
+// В gravity символы падают вниз, поэтому допустимо только отражение столбцов
+func (gravityRules) Symmetries() []Transform {
+	return []Transform{identity, mirrorColumns}
+}
+
+func (rollingRules) HistoryWindow() int {
+	return 2 * RollingMarks
+}
+
+func (r misereRules) Symmetries() []Transform {
+	if s, ok := r.Ruleset.(Symmetric); ok {
+		return s.Symmetries()
+	}
+	return nil
+}
+
+func (r misereRules) HistoryWindow() int {
+	if h, ok := r.Ruleset.(HistoryDependent); ok {
+		return h.HistoryWindow()
+	}
+	return 0
+}
+
+// historyWindow возвращает, сколько последних ходов входит в позицию, или 0, если позиция
+// задаётся одной доской. misere реализует HistoryDependent всегда, поэтому смотрим на значение
+func historyWindow(rules Ruleset) int {
+	if h, ok := rules.(HistoryDependent); ok {
+		return h.HistoryWindow()
+	}
+	return 0
+}
+
+// CanonicalKey возвращает ключ позиции, одинаковый для всех симметричных ей позиций,
+// и преобразование, которое переводит позицию в канонический вид
+func CanonicalKey(rules Ruleset, p *Position) (string, Transform) {
+	transforms := []Transform{identity}
+	if s, ok := rules.(Symmetric); ok && len(s.Symmetries()) > 0 {
+		transforms = s.Symmetries()
+	}
+	window := historyWindow(rules)
+
+	best, bestTransform := "", identity
+	for _, t := range transforms {
+		key := positionKey(p, t, window)
+		if best == "" || key < best {
+			best, bestTransform = key, t
+		}
+	}
+	return best, bestTransform
+}
+
+// positionKey записывает доску в преобразованном виде вместе с очередью хода,
+// активной малой доской и, если нужно, последними ходами
+func positionKey(p *Position, t Transform, window int) string {
+	n := p.Board.Size()
+	cells := make([]byte, n*n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			x, y := t(i, j, n)
+			c := byte('.')
+			if p.Board[i][j] != "" {
+				c = p.Board[i][j][0]
+			}
+			cells[x*n+y] = c
+		}
+	}
+
+	var sb strings.Builder
+	sb.Write(cells)
+	sb.WriteString(p.Turn)
+	if p.ActiveBoard != AnyBoard {
+		x, y := t(p.ActiveBoard/3, p.ActiveBoard%3, 3)
+		sb.WriteByte(byte('0' + x*3 + y))
+	}
+	if window > 0 {
+		start := len(p.History) - window
+		if start < 0 {
+			start = 0
+		}
+		for _, m := range p.History[start:] {
+			x, y := t(m.X, m.Y, n)
+			sb.WriteByte(byte('a' + x))
+			sb.WriteByte(byte('a' + y))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,118 @@
+package game
+
+import "errors"
+
+// AnyBoard означает, что в ultimate можно ходить на любую незакрытую малую доску
+const AnyBoard = -1
+
+var ErrWrongBoard = errors.New("You must play in the active board")
+
+// ultimateRules — 9 малых досок 3×3. Клетка, в которую сходил игрок, задаёт малую доску
+// для следующего хода соперника. Малая доска закрывается после победы на ней или заполнения,
+// партию выигрывает тот, кто соберёт три малые доски в ряд
+type ultimateRules struct{}
+
+func init() {
+	RegisterRuleset(ultimateRules{})
+}
+
+func (ultimateRules) Name() string {
+	return "ultimate"
+}
+
+func (ultimateRules) Normalize(opts Options) (Options, error) {
+	if (opts.Size != 0 && opts.Size != 9) || (opts.WinLength != 0 && opts.WinLength != 3) {
+		return opts, errors.New("ultimate is played on a 9x9 board with 3 in a row")
+	}
+	opts.Size = 9
+	opts.WinLength = 3
+	return opts, nil
+}
+
+func (ultimateRules) NewPosition(opts Options) Position {
+	return Position{Options: opts, Board: NewBoard(9), Turn: "X", ActiveBoard: AnyBoard}
+}
+
+func (r ultimateRules) LegalMoves(p *Position) []Move {
+	if r.Result(p).Finished {
+		return nil
+	}
+	moves := make([]Move, 0)
+	for i := range p.Board {
+		for j := range p.Board[i] {
+			if r.check(p, Move{X: i, Y: j}) == nil {
+				moves = append(moves, Move{X: i, Y: j})
+			}
+		}
+	}
+	return moves
+}
+
+func (r ultimateRules) Apply(p *Position, m Move) (Move, error) {
+	if r.Result(p).Finished {
+		return m, ErrGameFinished
+	}
+	if err := r.check(p, m); err != nil {
+		return m, err
+	}
+	p.place(m)
+
+	// Позиция клетки внутри малой доски задаёт доску для соперника
+	p.ActiveBoard = (m.X%3)*3 + m.Y%3
+	if _, closed := subBoardResult(p.Board, p.ActiveBoard); closed {
+		p.ActiveBoard = AnyBoard
+	}
+	return m, nil
+}
+
+func (ultimateRules) Result(p *Position) Result {
+	macro := NewBoard(3)
+	closedCount := 0
+	for b := 0; b < 9; b++ {
+		winner, closed := subBoardResult(p.Board, b)
+		macro[b/3][b%3] = winner
+		if closed {
+			closedCount++
+		}
+	}
+	if winner := macro.CheckWinner(3); winner != "" {
+		return Result{Finished: true, Winner: winner}
+	}
+	return Result{Finished: closedCount == 9}
+}
+
+func (ultimateRules) check(p *Position, m Move) error {
+	if !p.Board.InBounds(m.X, m.Y) {
+		return ErrInvalidCoordinates
+	}
+	if p.Board[m.X][m.Y] != "" {
+		return ErrCellOccupied
+	}
+	b := SubBoardIndex(m.X, m.Y)
+	if p.ActiveBoard != AnyBoard && b != p.ActiveBoard {
+		return ErrWrongBoard
+	}
+	if _, closed := subBoardResult(p.Board, b); closed {
+		return ErrWrongBoard
+	}
+	return nil
+}
+
+// SubBoardIndex возвращает номер малой доски (0..8) для клетки доски 9×9
+func SubBoardIndex(x, y int) int {
+	return (x/3)*3 + y/3
+}
+
+// subBoardResult возвращает победителя малой доски и признак того, что она закрыта
+func subBoardResult(board Board, b int) (string, bool) {
+	sub := NewBoard(3)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			sub[i][j] = board[(b/3)*3+i][(b%3)*3+j]
+		}
+	}
+	if winner := sub.CheckWinner(3); winner != "" {
+		return winner, true
+	}
+	return "", sub.IsFull()
+}
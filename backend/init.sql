@@ -1,6 +1,7 @@
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
-    nickname VARCHAR(50) UNIQUE NOT NULL
+    nickname VARCHAR(50) UNIQUE NOT NULL,
+    token_hash CHAR(64) UNIQUE
 );
 
 CREATE TABLE games (
@@ -11,6 +12,10 @@ CREATE TABLE games (
     turn VARCHAR(1) NOT NULL,
     board JSONB NOT NULL,
     winner_id INT REFERENCES users(id),
+    difficulty VARCHAR(20),
+    engine VARCHAR(50),
+    player1_hints INT NOT NULL DEFAULT 0,
+    player2_hints INT NOT NULL DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -22,14 +27,67 @@ CREATE TABLE moves (
     x INT NOT NULL,
     y INT NOT NULL,
     symbol VARCHAR(1) NOT NULL,
+    active_board INT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE offline_stats (
     id SERIAL PRIMARY KEY,
     player_id INT REFERENCES users(id),
+    difficulty VARCHAR(50),
     wins INT DEFAULT 0,
     losses INT DEFAULT 0,
     draws INT DEFAULT 0,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
\ No newline at end of file
+);
+
+CREATE UNIQUE INDEX offline_stats_player_difficulty ON offline_stats (player_id, difficulty);
+
+CREATE TABLE menace_weights (
+    position_key TEXT NOT NULL,
+    x INT NOT NULL,
+    y INT NOT NULL,
+    weight INT NOT NULL,
+    PRIMARY KEY (position_key, x, y)
+);
+
+CREATE TABLE menace_games (
+    game_id INT PRIMARY KEY REFERENCES games(id),
+    symbol VARCHAR(1) NOT NULL,
+    result VARCHAR(10) NOT NULL,
+    learned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE move_annotations (
+    game_id INT REFERENCES games(id),
+    ply INT NOT NULL,
+    player_id INT REFERENCES users(id),
+    symbol VARCHAR(1) NOT NULL,
+    x INT NOT NULL,
+    y INT NOT NULL,
+    best_x INT NOT NULL,
+    best_y INT NOT NULL,
+    score INT NOT NULL,
+    best_score INT NOT NULL,
+    label VARCHAR(20) NOT NULL,
+    PRIMARY KEY (game_id, ply)
+);
+
+CREATE TABLE ratings (
+    player_id INT PRIMARY KEY REFERENCES users(id),
+    rating DOUBLE PRECISION NOT NULL,
+    deviation DOUBLE PRECISION NOT NULL,
+    volatility DOUBLE PRECISION NOT NULL,
+    games INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE rating_history (
+    id SERIAL PRIMARY KEY,
+    game_id INT REFERENCES games(id),
+    player_id INT REFERENCES users(id),
+    rating_before DOUBLE PRECISION NOT NULL,
+    rating_after DOUBLE PRECISION NOT NULL,
+    deviation_after DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
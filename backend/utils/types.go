@@ -1,7 +1,9 @@
 package utils
 
 type GameState struct {
-	Board  [3][3]string `json:"board"`
-	Turn   string       `json:"turn"`
-	Status string       `json:"status"`
+	Board     [][]string `json:"board"`
+	Size      int        `json:"size"`
+	WinLength int        `json:"winLength"`
+	Turn      string     `json:"turn"`
+	Status    string     `json:"status"`
 }
\ No newline at end of file
@@ -1,15 +1,12 @@
 package ws
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"strconv"
-	"time"
 
 	"github.com/gorilla/websocket"
 
-	"tictactoe/db"
 	"tictactoe/game"
 )
 
@@ -32,17 +29,22 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Игрок подтверждает, кто он, токеном из ответа /quick-game, /offline-game или /rooms:
+	// по одному playerID можно было бы занять чужое место в партии
+	playerID, _, err := game.PlayerByToken(r.URL.Query().Get("token"))
+	if errors.Is(err, game.ErrUnknownToken) {
+		http.Error(w, "Unknown player token", http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
-		log.Println("WebSocket upgrade failed:", err)
+		log.Println("Failed to authenticate player:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
 
-	playerIDStr := r.URL.Query().Get("playerID")
-	playerID, err := strconv.Atoi(playerIDStr)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Invalid playerID:", err)
-		sendError(conn, "Invalid playerID")
+		log.Println("WebSocket upgrade failed:", err)
 		return
 	}
 
@@ -53,7 +55,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	defer func() {
-		gm.HandleDisconnect(playerID)
+		gm.HandleDisconnect(playerID, conn)
 		conn.Close()
 	}()
 
@@ -77,6 +79,10 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			gameID, ok1 := msg["gameID"].(float64)
 			x, ok2 := msg["x"].(float64)
 			y, ok3 := msg["y"].(float64)
+			// В gravity клиент присылает только столбец, клетку определяет сервер
+			if column, ok := msg["column"].(float64); ok && !ok2 && !ok3 {
+				x, y, ok2, ok3 = -1, column, true, true
+			}
 			if !ok1 || !ok2 || !ok3 {
 				sendError(conn, "Invalid move coordinates or game ID")
 				continue
@@ -89,79 +95,30 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 				sendError(conn, "Invalid game ID")
 				continue
 			}
-			game, ok := gm.GetGame(int(gameID))
-			if !ok || game.Player2ID != 0 {
-				sendError(conn, "Invalid game or not offline mode")
-				continue
-			}
-			x, y := game.MakeAIMove()
-			if x == -1 && y == -1 {
-				sendError(conn, "No available moves")
-				continue
-			}
+			gm.HandleAIMove(int(gameID), playerID)
 
-			_, err := db.DB.Exec(
-				"INSERT INTO moves (game_id, player_id, x, y, symbol) VALUES ($1, $2, $3, $4, $5)",
-				int(gameID), nil, x, y, "O",
-			)
-			if err != nil {
-				log.Printf("Failed to save AI move for game %d: %v", gameID, err)
+		case "hint":
+			gameID, ok := msg["gameID"].(float64)
+			if !ok {
+				sendError(conn, "Invalid game ID")
+				continue
 			}
+			gm.HandleHint(int(gameID), playerID)
 
-			winner := game.Board.CheckWinner()
-			if winner != "" {
-				game.Status = "finished"
-				if winner == "X" {
-					game.WinnerID = game.Player1ID
-				}
-				updateOfflineStats(playerID, winner, game)
-			} else {
-				isDraw := true
-				for i := 0; i < 3; i++ {
-					for j := 0; j < 3; j++ {
-						if game.Board[i][j] == "" {
-							isDraw = false
-							break
-						}
-					}
-					if !isDraw {
-						break
-					}
-				}
-				if isDraw {
-					game.Status = "finished"
-					updateOfflineStats(playerID, "", game)
-				}
+		case "select_role":
+			gameID, ok1 := msg["gameID"].(float64)
+			role, ok2 := msg["role"].(string)
+			if !ok1 || !ok2 {
+				sendError(conn, "Invalid game ID or role")
+				continue
 			}
+			gm.HandleSelectRole(int(gameID), playerID, role)
 
-			boardJSON, _ := json.Marshal(game.Board)
-			var updateErr error
-			if game.WinnerID != 0 {
-				_, updateErr = db.DB.Exec(
-					"UPDATE games SET status=$1, turn=$2, board=$3, winner_id=$4, updated_at=$5 WHERE id=$6",
-					game.Status, game.Turn, boardJSON, game.WinnerID, time.Now(), int(gameID),
-				)
-			} else {
-				_, updateErr = db.DB.Exec(
-					"UPDATE games SET status=$1, turn=$2, board=$3, updated_at=$4 WHERE id=$5",
-					game.Status, game.Turn, boardJSON, time.Now(), int(gameID),
-				)
-			}
-			if updateErr != nil {
-				log.Printf("Failed to update game %d: %v", gameID, updateErr)
-			}
+		case "leave_queue":
+			gm.LeaveQueue(playerID)
 
-			state := map[string]interface{}{
-				"type":   "ai_move",
-				"x":      x,
-				"y":      y,
-				"board":  game.Board,
-				"turn":   game.Turn,
-				"status": game.Status,
-			}
-			if err := conn.WriteJSON(state); err != nil {
-				log.Println("Failed to send AI move:", err)
-			}
+		case "accept_ai":
+			gm.AcceptAIOffer(playerID)
 
 		case "rematch_request":
 			gameID, ok := msg["gameID"].(float64)
@@ -187,7 +144,20 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 			opponentID := int(opponentIDFloat)
-			newGameID := gm.CreateRematch(playerID, opponentID)
+			oldGameID, ok := msg["gameID"].(float64)
+			if !ok {
+				sendError(conn, "Invalid game ID")
+				continue
+			}
+			newGameID, created, err := gm.CreateRematch(int(oldGameID), playerID, opponentID)
+			if err != nil {
+				sendError(conn, err.Error())
+				continue
+			}
+			if !created {
+				// Партию уже создал start_rematch соперника, и оба о ней знают
+				continue
+			}
 			game, ok := gm.GetGame(newGameID)
 			if !ok {
 				sendError(conn, "Failed to create rematch game")
@@ -201,36 +171,8 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func updateOfflineStats(playerID int, winner string, game *game.Game) {
-	var query string
-	if winner == "X" {
-		query = "UPDATE offline_stats SET wins = wins + 1, updated_at = $2 WHERE player_id = $1"
-		game.WinnerID = playerID
-	} else if winner == "O" {
-		query = "UPDATE offline_stats SET losses = losses + 1, updated_at = $2 WHERE player_id = $1"
-	} else {
-		query = "UPDATE offline_stats SET draws = draws + 1, updated_at = $2 WHERE player_id = $1"
-	}
-
-	_, err := db.DB.Exec(query, playerID, time.Now())
-	if err != nil {
-		log.Printf("Failed to update offline stats for player %d: %v", playerID, err)
-		_, err = db.DB.Exec(
-			"INSERT INTO offline_stats (player_id, wins, losses, draws) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
-			playerID, 0, 0, 0,
-		)
-		if err != nil {
-			log.Printf("Failed to create offline stats for player %d: %v", playerID, err)
-		}
-		_, err = db.DB.Exec(query, playerID, time.Now())
-		if err != nil {
-			log.Printf("Failed to update offline stats after creation for player %d: %v", playerID, err)
-		}
-	}
-}
-
 func sendError(conn *websocket.Conn, message string) {
 	if err := conn.WriteJSON(map[string]string{"type": "warning", "message": message}); err != nil {
 		log.Println("Failed to send error message:", err)
 	}
-}
\ No newline at end of file
+}
@@ -2,6 +2,7 @@ const backendUrl = 'http://localhost:8080'
 const wsUrl = 'ws://localhost:8080/ws'
 let ws = null
 let playerID = null
+let playerToken = null
 let gameID = null
 let currentTurn = 'X'
 let mySymbol = 'X'
@@ -61,6 +62,7 @@ async function startOnlineGame() {
 			return
 		}
 		playerID = data.playerID
+		playerToken = data.token
 		gameID = data.opponentID || null
 		opponentID = data.opponentID || null
 		isOffline = false
@@ -110,6 +112,7 @@ async function startOfflineGame() {
 			return
 		}
 		playerID = data.playerID
+		playerToken = data.token
 		gameID = data.gameID
 		isOffline = true
 		mySymbol = 'X'
@@ -163,7 +166,7 @@ function initWebSocket() {
 		ws.close()
 	}
 
-	ws = new WebSocket(`${wsUrl}?playerID=${playerID}`)
+	ws = new WebSocket(`${wsUrl}?token=${encodeURIComponent(playerToken)}`)
 
 	ws.onopen = () => {
 		if (gameID && ws && ws.readyState === WebSocket.OPEN) {
@@ -633,6 +636,7 @@ function showMainMenu() {
 		ws = null
 	}
 	playerID = null
+	playerToken = null
 	gameID = null
 	opponentID = null
 	rematchRequested = false